@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// Alert events
+const (
+	AlertFail     = "fail"     // service stopped being authenticated
+	AlertRecover  = "recover"  // service is authenticated again
+	AlertExpiring = "expiring" // credentials expire within the warning window
+)
+
+var alertEvents = []string{AlertFail, AlertRecover, AlertExpiring}
+
+// Service severities, lowest first
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+var severities = []string{SeverityInfo, SeverityWarning, SeverityCritical}
+
+// Alert is something worth telling the user about. It is the JSON body
+// webhooks receive unless they configure a template.
+type Alert struct {
+	ID            string     `json:"id"`
+	Event         string     `json:"event"`
+	Service       string     `json:"service"`
+	Severity      string     `json:"severity"`
+	State         CheckState `json:"state"`
+	PreviousState CheckState `json:"previous_state,omitempty"`
+	Error         string     `json:"error,omitempty"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+	Time          time.Time  `json:"time"`
+	Host          string     `json:"host"`
+
+	hasAuth bool // service has an auth_cmd
+}
+
+// serviceSeverity returns the service's severity; critical: true wins
+func serviceSeverity(svc Service) string {
+	if svc.Critical {
+		return SeverityCritical
+	}
+	if svc.Severity != "" {
+		return svc.Severity
+	}
+	return SeverityWarning
+}
+
+// severityRank orders severities; unknown ones rank lowest
+func severityRank(severity string) int {
+	for i, s := range severities {
+		if s == severity {
+			return i
+		}
+	}
+	return -1
+}
+
+// alertDetector turns transitions and fresh results into alerts
+type alertDetector struct {
+	host     string
+	expiring map[string]string // service -> ID of the warning for its current window
+	seq      int
+}
+
+func newAlertDetector() *alertDetector {
+	host, _ := os.Hostname()
+	return &alertDetector{host: host, expiring: make(map[string]string)}
+}
+
+// Detect returns alerts for services that started failing or recovered,
+// and for sessions about to expire. The expiry warning is repeated on every
+// check in the warning window under the same ID, so each sink can deliver
+// it once after it succeeds; see expiryWarnings.
+func (d *alertDetector) Detect(config *Config, transitions []Transition, fresh []ServiceStatus) []Alert {
+	var alerts []Alert
+
+	for _, tr := range transitions {
+		event := ""
+		switch {
+		case isFailing(tr.To) && !isFailing(tr.From):
+			event = AlertFail
+		case tr.To == StateOK && isFailing(tr.From):
+			event = AlertRecover
+		default:
+			continue
+		}
+		if a, ok := d.alert(config, event, tr.Status); ok {
+			a.PreviousState = tr.From
+			alerts = append(alerts, a)
+		}
+	}
+
+	for _, st := range fresh {
+		if !isExpiringSoon(st) {
+			delete(d.expiring, st.Name)
+			continue
+		}
+		if a, ok := d.alert(config, AlertExpiring, st); ok {
+			if id, ok := d.expiring[st.Name]; ok {
+				a.ID = id
+			}
+			d.expiring[st.Name] = a.ID
+			alerts = append(alerts, a)
+		}
+	}
+	return alerts
+}
+
+func (d *alertDetector) alert(config *Config, event string, st ServiceStatus) (Alert, bool) {
+	svc, ok := findService(config, st.Name)
+	if !ok {
+		return Alert{}, false
+	}
+
+	d.seq++
+	now := time.Now()
+	return Alert{
+		ID:        fmt.Sprintf("%d-%d", now.UnixNano(), d.seq),
+		Event:     event,
+		Service:   st.Name,
+		Severity:  serviceSeverity(svc),
+		State:     statusState(st),
+		Error:     st.Error,
+		ExpiresAt: st.ExpiresAt,
+		Time:      now,
+		Host:      d.host,
+		hasAuth:   svc.AuthCmd != "",
+	}, true
+}
+
+// expiryWarnings tracks the expiry warnings a sink has delivered, by service
+type expiryWarnings map[string]string
+
+// due reports whether a still needs delivering: anything but an expiry
+// warning, or one that is newer than the last delivered for its service
+func (w expiryWarnings) due(a Alert) bool {
+	return a.Event != AlertExpiring || w[a.Service] != a.ID
+}
+
+// delivered records that a was delivered
+func (w expiryWarnings) delivered(a Alert) {
+	if a.Event == AlertExpiring {
+		w[a.Service] = a.ID
+	}
+}
+
+// Summary is a one-line description of the alert
+func (a Alert) Summary() string {
+	switch a.Event {
+	case AlertFail:
+		return fmt.Sprintf("%s: %s", a.Service, failureSummary(a.State))
+	case AlertRecover:
+		return fmt.Sprintf("%s: authenticated again", a.Service)
+	case AlertExpiring:
+		if a.ExpiresAt != nil {
+			return fmt.Sprintf("%s: session expires in %s", a.Service, formatCountdown(time.Until(*a.ExpiresAt)))
+		}
+		return fmt.Sprintf("%s: session expires soon", a.Service)
+	}
+	return fmt.Sprintf("%s: %s", a.Service, a.State)
+}
+
+// failureSummary describes a failing state for a notification title
+func failureSummary(state CheckState) string {
+	switch state {
+	case StateUnauthenticated:
+		return "not authenticated"
+	case StateTimeout:
+		return "check timed out"
+	}
+	return "check failed"
+}
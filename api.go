@@ -0,0 +1,341 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// apiHeartbeat keeps idle event streams from being closed by proxies
+const apiHeartbeat = 30 * time.Second
+
+// APIEvent is sent on /v1/events when a service changes state
+type APIEvent struct {
+	Service string        `json:"service"`
+	From    CheckState    `json:"from"`
+	To      CheckState    `json:"to"`
+	Status  ServiceStatus `json:"status"`
+	Time    time.Time     `json:"time"`
+}
+
+// APIError is the body of every non-2xx response
+type APIError struct {
+	Error string `json:"error"`
+}
+
+// APICheckRequest is the optional body of POST /v1/check
+type APICheckRequest struct {
+	Services []string `json:"services,omitempty"`
+}
+
+// apiServer serves the HTTP API. Like the control socket it answers reads
+// from the last published state and hands checks to the daemon loop.
+type apiServer struct {
+	logger *Logger
+	calls  chan controlCall
+
+	mu          sync.Mutex
+	cfg         APIConfig
+	server      *http.Server
+	socket      string // path to remove on close when listening on a Unix socket
+	state       *State
+	subscribers map[chan APIEvent]struct{}
+}
+
+func newAPIServer(logger *Logger) *apiServer {
+	return &apiServer{
+		logger:      logger,
+		calls:       make(chan controlCall),
+		subscribers: make(map[chan APIEvent]struct{}),
+	}
+}
+
+// Configure starts, stops or moves the server to match cfg.
+// A new token applies to the next request without a restart.
+// A TCP address is refused without a token or off loopback, since the
+// token is sent in plain HTTP, even if the config was loaded leniently.
+func (s *apiServer) Configure(cfg APIConfig) {
+	if isTCPListen(cfg.Listen) && os.ExpandEnv(cfg.Token) == "" {
+		s.logger.Errorf("API disabled: api.token is required when api.listen is a TCP address")
+		cfg.Listen = ""
+	}
+	if isTCPListen(cfg.Listen) && !isLoopbackListen(cfg.Listen) {
+		s.logger.Errorf("API disabled: api.listen must be a loopback address such as 127.0.0.1:9877, got %q", cfg.Listen)
+		cfg.Listen = ""
+	}
+
+	s.mu.Lock()
+	// A failed listen is tried again on reload
+	moved := cfg.Listen != s.cfg.Listen || (cfg.Listen != "" && s.server == nil)
+	s.cfg = cfg
+	s.mu.Unlock()
+	if !moved {
+		return
+	}
+
+	s.Close()
+	if cfg.Listen == "" {
+		return
+	}
+
+	listener, socket, err := listenAPI(cfg.Listen)
+	if err != nil {
+		s.logger.Errorf("API disabled: %v", err)
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/status", s.handleStatus)
+	mux.HandleFunc("GET /v1/services/{name}", s.handleService)
+	mux.HandleFunc("POST /v1/check", s.handleCheck)
+	mux.HandleFunc("GET /v1/events", s.handleEvents)
+	server := &http.Server{Handler: s.authenticate(mux), ReadHeaderTimeout: 10 * time.Second}
+
+	s.mu.Lock()
+	s.server = server
+	s.socket = socket
+	s.mu.Unlock()
+
+	s.logger.Infof("Serving API on %s", cfg.Listen)
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.logger.Errorf("API server stopped: %v", err)
+		}
+	}()
+}
+
+// isTCPListen reports whether api.listen is a TCP address rather than a Unix socket
+func isTCPListen(addr string) bool {
+	return addr != "" && !strings.HasPrefix(addr, "unix:")
+}
+
+// isLoopbackListen reports whether a host:port only accepts local
+// connections. An empty host listens on every interface.
+func isLoopbackListen(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// listenAPI listens on host:port, or on a Unix socket for "unix:/path".
+// Environment variables in the path are expanded.
+func listenAPI(addr string) (net.Listener, string, error) {
+	path, ok := strings.CutPrefix(addr, "unix:")
+	if !ok {
+		l, err := net.Listen("tcp", addr)
+		return l, "", err
+	}
+
+	path = os.ExpandEnv(path)
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return nil, "", fmt.Errorf("another process is listening on %s", path)
+	}
+	os.Remove(path)
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, "", err
+	}
+	os.Chmod(path, 0600)
+	return l, path, nil
+}
+
+// Close shuts the server down and ends event streams
+func (s *apiServer) Close() {
+	s.mu.Lock()
+	server, socket := s.server, s.socket
+	s.server, s.socket = nil, ""
+	for ch := range s.subscribers {
+		close(ch)
+		delete(s.subscribers, ch)
+	}
+	s.mu.Unlock()
+
+	if server == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	server.Shutdown(ctx)
+	if socket != "" {
+		os.Remove(socket)
+	}
+}
+
+// Publish records the latest state and streams the transitions that led to it
+func (s *apiServer) Publish(state *State, transitions []Transition) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.state = state
+	now := time.Now()
+	for _, tr := range transitions {
+		ev := APIEvent{Service: tr.Service, From: tr.From, To: tr.To, Status: tr.Status, Time: now}
+		for ch := range s.subscribers {
+			select {
+			case ch <- ev:
+			default:
+				// A client that can't keep up misses events rather
+				// than holding up the daemon
+			}
+		}
+	}
+}
+
+func (s *apiServer) currentState() *State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.state == nil {
+		return &State{SchemaVersion: StateSchemaVersion}
+	}
+	return s.state
+}
+
+// authenticate requires the configured token as a bearer token. The event
+// stream also takes ?token= for clients such as EventSource that can't set
+// headers; other endpoints don't, to keep the token out of access logs.
+func (s *apiServer) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		token := os.ExpandEnv(s.cfg.Token)
+		tcp := isTCPListen(s.cfg.Listen)
+		s.mu.Unlock()
+
+		if token != "" || tcp {
+			got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok && r.Method == http.MethodGet && r.URL.Path == "/v1/events" {
+				got = r.URL.Query().Get("token")
+			}
+			if token == "" || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="gatekeeper"`)
+				writeAPIError(w, http.StatusUnauthorized, "missing or invalid token")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *apiServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	writeAPIJSON(w, http.StatusOK, s.currentState())
+}
+
+func (s *apiServer) handleService(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	for _, st := range s.currentState().Services {
+		if strings.EqualFold(st.Name, name) {
+			writeAPIJSON(w, http.StatusOK, st)
+			return
+		}
+	}
+	writeAPIError(w, http.StatusNotFound, fmt.Sprintf("unknown service %q", name))
+}
+
+// handleCheck runs checks and answers with the resulting state once they finish
+func (s *apiServer) handleCheck(w http.ResponseWriter, r *http.Request) {
+	var body APICheckRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+			return
+		}
+	}
+	body.Services = append(body.Services, r.URL.Query()["service"]...)
+
+	call := controlCall{
+		req:   ControlRequest{Cmd: ControlCheck, Services: body.Services},
+		reply: make(chan ControlResponse, 1),
+	}
+	select {
+	case s.calls <- call:
+	case <-r.Context().Done():
+		return
+	}
+
+	select {
+	case resp := <-call.reply:
+		if !resp.OK {
+			writeAPIError(w, http.StatusBadRequest, resp.Error)
+			return
+		}
+		writeAPIJSON(w, http.StatusOK, resp.State)
+	case <-r.Context().Done():
+	}
+}
+
+// handleEvents streams transitions as Server-Sent Events
+func (s *apiServer) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeAPIError(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+
+	ch := make(chan APIEvent, 16)
+	s.mu.Lock()
+	s.subscribers[ch] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		if _, ok := s.subscribers[ch]; ok {
+			delete(s.subscribers, ch)
+			close(ch)
+		}
+		s.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(apiHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case ev, ok := <-ch:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(ev)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: transition\ndata: %s\n\n", data)
+			flusher.Flush()
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func writeAPIJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func writeAPIError(w http.ResponseWriter, code int, msg string) {
+	writeAPIJSON(w, code, APIError{Error: msg})
+}
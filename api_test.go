@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// freeAddr returns a local TCP address nothing is listening on
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	return addr
+}
+
+func TestAPIRefusesTCPWithoutToken(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("GATEKEEPER_API_TOKEN", "")
+	s := newAPIServer(NewLogger(LogDebug))
+	defer s.Close()
+
+	// As loaded by 'start --lenient', which skips validation
+	addr := freeAddr(t)
+	s.Configure(APIConfig{Listen: addr, Token: "${GATEKEEPER_API_TOKEN}"})
+	if s.server != nil {
+		t.Fatal("API started on TCP without a token")
+	}
+	if conn, err := net.Dial("tcp", addr); err == nil {
+		conn.Close()
+		t.Fatal("something is listening on the API address")
+	}
+
+	// Setting a token on reload starts it
+	t.Setenv("GATEKEEPER_API_TOKEN", "secret")
+	s.Configure(APIConfig{Listen: addr, Token: "${GATEKEEPER_API_TOKEN}"})
+	for _, tc := range []struct {
+		auth string
+		want int
+	}{
+		{"", http.StatusUnauthorized},
+		{"Bearer wrong", http.StatusUnauthorized},
+		{"Bearer secret", http.StatusOK},
+	} {
+		req, _ := http.NewRequest(http.MethodGet, "http://"+addr+"/v1/status", nil)
+		if tc.auth != "" {
+			req.Header.Set("Authorization", tc.auth)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.want {
+			t.Errorf("Authorization %q: got %d, want %d", tc.auth, resp.StatusCode, tc.want)
+		}
+	}
+
+	// Dropping the token again stops it
+	t.Setenv("GATEKEEPER_API_TOKEN", "")
+	s.Configure(APIConfig{Listen: addr, Token: "${GATEKEEPER_API_TOKEN}"})
+	if s.server != nil {
+		t.Error("API kept serving TCP after its token was removed")
+	}
+}
+
+func TestAPIAuthenticateEmptyTokenOnTCP(t *testing.T) {
+	s := &apiServer{cfg: APIConfig{Listen: "127.0.0.1:9877"}}
+	handler := s.authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for _, target := range []string{"/v1/status", "/v1/events?token="} {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set("Authorization", "Bearer ")
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("%s: got %d with an empty token, want 401", target, rec.Code)
+		}
+	}
+
+	// ?token= is only for the event stream
+	s.cfg.Token = "secret"
+	for _, tc := range []struct {
+		method, target string
+		want           int
+	}{
+		{http.MethodGet, "/v1/events?token=secret", http.StatusOK},
+		{http.MethodGet, "/v1/status?token=secret", http.StatusUnauthorized},
+		{http.MethodGet, "/v1/services/GitHub?token=secret", http.StatusUnauthorized},
+		{http.MethodPost, "/v1/check?token=secret", http.StatusUnauthorized},
+		{http.MethodPost, "/v1/events?token=secret", http.StatusUnauthorized},
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(tc.method, tc.target, nil))
+		if rec.Code != tc.want {
+			t.Errorf("%s %s: got %d, want %d", tc.method, tc.target, rec.Code, tc.want)
+		}
+	}
+	s.cfg.Token = ""
+
+	// A Unix socket is protected by its file mode instead
+	s.cfg.Listen = "unix:/tmp/gatekeeper-api.sock"
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/status", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("unix socket without token: got %d, want 200", rec.Code)
+	}
+}
+
+func TestAPIRefusesNonLoopbackTCP(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"127.0.0.1:9877":      true,
+		"127.0.0.2:9877":      true,
+		"[::1]:9877":          true,
+		"localhost:9877":      true,
+		"0.0.0.0:9877":        false,
+		":9877":               false,
+		"[::]:9877":           false,
+		"192.168.1.10:9877":   false,
+		"gatekeeper.lan:9877": false,
+	} {
+		if got := isLoopbackListen(addr); got != want {
+			t.Errorf("isLoopbackListen(%q) = %v, want %v", addr, got, want)
+		}
+	}
+
+	t.Setenv("HOME", t.TempDir())
+	s := newAPIServer(NewLogger(LogDebug))
+	defer s.Close()
+	s.Configure(APIConfig{Listen: "0.0.0.0:0", Token: "secret"})
+	if s.server != nil {
+		t.Error("API started on all interfaces")
+	}
+
+	_, issues, err := validateConfig("config.yaml", []byte("api:\n  listen: 0.0.0.0:9877\n  token: secret\nservices:\n  - name: A\n    check_cmd: \"true\"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(issues) != 1 || !strings.Contains(issues[0].String(), "api.listen must be a loopback address") {
+		t.Errorf("got issues %v, want the loopback error", issues)
+	}
+}
+
+// newTestAPI serves the API on a loopback port with token "secret"
+func newTestAPI(t *testing.T) (*apiServer, string) {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	s := newAPIServer(NewLogger(LogDebug))
+	t.Cleanup(s.Close)
+	addr := freeAddr(t)
+	s.Configure(APIConfig{Listen: addr, Token: "secret"})
+	if s.server == nil {
+		t.Fatal("API not started")
+	}
+	return s, "http://" + addr
+}
+
+func apiRequest(t *testing.T, method, url, body string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestAPIService(t *testing.T) {
+	s, base := newTestAPI(t)
+	s.Publish(&State{SchemaVersion: StateSchemaVersion, Services: []ServiceStatus{
+		{Name: "GitHub", State: StateOK, IsAlive: true},
+	}}, nil)
+
+	resp := apiRequest(t, http.MethodGet, base+"/v1/services/github", "")
+	var st ServiceStatus
+	if err := json.NewDecoder(resp.Body).Decode(&st); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || st.Name != "GitHub" || st.State != StateOK {
+		t.Errorf("got %d %+v, want GitHub ok", resp.StatusCode, st)
+	}
+
+	resp = apiRequest(t, http.MethodGet, base+"/v1/services/Vault", "")
+	var apiErr APIError
+	if err := json.NewDecoder(resp.Body).Decode(&apiErr); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusNotFound || apiErr.Error != `unknown service "Vault"` {
+		t.Errorf("got %d %+v, want 404 for an unknown service", resp.StatusCode, apiErr)
+	}
+}
+
+func TestAPICheck(t *testing.T) {
+	s, base := newTestAPI(t)
+
+	// Stands in for the daemon loop
+	requests := make(chan ControlRequest, 2)
+	go func() {
+		for call := range s.calls {
+			requests <- call.req
+			if slices.Contains(call.req.Services, "nope") {
+				call.reply <- ControlResponse{Error: "unknown service(s): nope"}
+				continue
+			}
+			call.reply <- ControlResponse{OK: true, State: &State{Services: []ServiceStatus{{Name: "GitHub", State: StateOK}}}}
+		}
+	}()
+	defer close(s.calls)
+
+	resp := apiRequest(t, http.MethodPost, base+"/v1/check?service=Vault", `{"services": ["GitHub"]}`)
+	var state State
+	if err := json.NewDecoder(resp.Body).Decode(&state); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || len(state.Services) != 1 || state.Services[0].Name != "GitHub" {
+		t.Errorf("got %d %+v, want the daemon's state", resp.StatusCode, state)
+	}
+	if req := <-requests; req.Cmd != ControlCheck || !slices.Equal(req.Services, []string{"GitHub", "Vault"}) {
+		t.Errorf("daemon got %+v, want a check of GitHub and Vault", req)
+	}
+
+	resp = apiRequest(t, http.MethodPost, base+"/v1/check?service=nope", "")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("got %d for an unknown service, want 400", resp.StatusCode)
+	}
+	<-requests
+}
+
+func TestAPIEvents(t *testing.T) {
+	s, base := newTestAPI(t)
+	resp := apiRequest(t, http.MethodGet, base+"/v1/events", "")
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("got Content-Type %q", ct)
+	}
+	lines := bufio.NewReader(resp.Body)
+	if line, err := lines.ReadString('\n'); err != nil || line != ": connected\n" {
+		t.Fatalf("got %q, %v before any event", line, err)
+	}
+	lines.ReadString('\n')
+
+	status := ServiceStatus{Name: "AWS", State: StateUnauthenticated, Error: "exit code 1"}
+	s.Publish(&State{Services: []ServiceStatus{status}}, []Transition{
+		{Service: "AWS", From: StateOK, To: StateUnauthenticated, Status: status},
+	})
+
+	if line, _ := lines.ReadString('\n'); line != "event: transition\n" {
+		t.Fatalf("got %q, want a transition event", line)
+	}
+	line, _ := lines.ReadString('\n')
+	var ev APIEvent
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &ev); err != nil {
+		t.Fatalf("bad event data %q: %v", line, err)
+	}
+	if ev.Service != "AWS" || ev.From != StateOK || ev.To != StateUnauthenticated || ev.Status.Error != "exit code 1" {
+		t.Errorf("got event %+v", ev)
+	}
+}
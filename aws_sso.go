@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Service types
+const (
+	ServiceTypeCommand = "command" // run check_cmd (default)
+	ServiceTypeAWSSSO  = "aws_sso" // read the AWS CLI token caches directly
+)
+
+// AWS cache modes for aws_sso services
+const (
+	AWSCacheSSO = "sso" // ~/.aws/sso/cache, the SSO access token (default)
+	AWSCacheCLI = "cli" // ~/.aws/cli/cache, assumed-role credentials
+)
+
+// awsProfile holds the ~/.aws/config settings the checker needs
+type awsProfile struct {
+	Name         string
+	SSOSession   string
+	SSOStartURL  string
+	SSOAccountID string
+	SSORoleName  string
+	RoleARN      string
+}
+
+// awsSSOToken is the subset of an ~/.aws/sso/cache/*.json file we use
+type awsSSOToken struct {
+	AccessToken string `json:"accessToken"`
+	ExpiresAt   string `json:"expiresAt"`
+	StartURL    string `json:"startUrl"`
+}
+
+// awsCLICacheEntry is the subset of an ~/.aws/cli/cache/*.json file we use
+type awsCLICacheEntry struct {
+	Credentials struct {
+		AccessKeyID string `json:"AccessKeyId"`
+		Expiration  string `json:"Expiration"`
+	} `json:"Credentials"`
+	AssumedRoleUser struct {
+		Arn string `json:"Arn"`
+	} `json:"AssumedRoleUser"`
+}
+
+// awsDir returns ~/.aws
+func awsDir() string {
+	return filepath.Join(getUserHomeDir(), ".aws")
+}
+
+// awsConfigPath honors AWS_CONFIG_FILE like the AWS CLI does
+func awsConfigPath() string {
+	if path := os.Getenv("AWS_CONFIG_FILE"); path != "" {
+		return path
+	}
+	return filepath.Join(awsDir(), "config")
+}
+
+// checkAWSSSO reports whether the profile has unexpired cached credentials.
+// A missing or expired cache entry means unauthenticated; problems with the
+// AWS config itself are errors. The expiry is returned whenever a cache entry
+// was found, even if expired.
+func checkAWSSSO(service Service, now time.Time) (CheckState, *time.Time, error) {
+	profile, err := loadAWSProfile(awsConfigPath(), service.Profile)
+	if err != nil {
+		return StateError, nil, err
+	}
+
+	var expiresAt time.Time
+	if service.AWSCache == AWSCacheCLI {
+		expiresAt, err = findAWSCLICacheExpiry(filepath.Join(awsDir(), "cli", "cache"), profile)
+	} else {
+		expiresAt, err = findAWSSSOTokenExpiry(filepath.Join(awsDir(), "sso", "cache"), profile)
+	}
+	if errors.Is(err, errAWSNoCredentials) {
+		return StateUnauthenticated, nil, err
+	}
+	if err != nil {
+		return StateError, nil, err
+	}
+
+	if !expiresAt.After(now) {
+		return StateUnauthenticated, &expiresAt, fmt.Errorf("session expired at %s", expiresAt.Local().Format("2006-01-02 15:04"))
+	}
+	return StateOK, &expiresAt, nil
+}
+
+// errAWSNoCredentials means the cache has nothing for the profile (not logged in)
+var errAWSNoCredentials = errors.New("no cached credentials")
+
+// loadAWSProfile reads a profile from an AWS config file, resolving
+// the sso-session section it refers to
+func loadAWSProfile(path, name string) (*awsProfile, error) {
+	if name == "" {
+		name = "default"
+	}
+
+	sections, err := parseINI(path)
+	if err != nil {
+		return nil, err
+	}
+
+	section, ok := sections["profile "+name]
+	if !ok && name == "default" {
+		section, ok = sections["default"]
+	}
+	if !ok {
+		return nil, fmt.Errorf("profile %q not found in %s", name, path)
+	}
+
+	profile := &awsProfile{
+		Name:         name,
+		SSOSession:   section["sso_session"],
+		SSOStartURL:  section["sso_start_url"],
+		SSOAccountID: section["sso_account_id"],
+		SSORoleName:  section["sso_role_name"],
+		RoleARN:      section["role_arn"],
+	}
+
+	if profile.SSOSession != "" {
+		session, ok := sections["sso-session "+profile.SSOSession]
+		if !ok {
+			return nil, fmt.Errorf("sso-session %q not found in %s", profile.SSOSession, path)
+		}
+		if profile.SSOStartURL == "" {
+			profile.SSOStartURL = session["sso_start_url"]
+		}
+	}
+
+	return profile, nil
+}
+
+// parseINI reads an AWS style INI file into section -> key -> value
+func parseINI(path string) (map[string]map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sections := make(map[string]map[string]string)
+	var current map[string]string
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		raw := scanner.Text()
+		line := strings.TrimSpace(raw)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		// Nested values (e.g. s3 settings) are indented continuation lines
+		if raw[0] == ' ' || raw[0] == '\t' {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			name := strings.Join(strings.Fields(line[1:len(line)-1]), " ")
+			current = make(map[string]string)
+			sections[name] = current
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || current == nil {
+			continue
+		}
+		current[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return sections, scanner.Err()
+}
+
+// findAWSSSOTokenExpiry locates the cached SSO token for a profile. The AWS CLI
+// names the file after the SHA-1 of the sso-session name, or of the start URL
+// for legacy profiles configured without an sso-session.
+func findAWSSSOTokenExpiry(cacheDir string, profile *awsProfile) (time.Time, error) {
+	key := profile.SSOSession
+	if key == "" {
+		key = profile.SSOStartURL
+	}
+	if key == "" {
+		return time.Time{}, fmt.Errorf("profile %q is not an SSO profile", profile.Name)
+	}
+
+	sum := sha1.Sum([]byte(key))
+	path := filepath.Join(cacheDir, hex.EncodeToString(sum[:])+".json")
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return time.Time{}, fmt.Errorf("%w: no SSO token for profile %q (run aws sso login)", errAWSNoCredentials, profile.Name)
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var token awsSSOToken
+	if err := json.Unmarshal(data, &token); err != nil {
+		return time.Time{}, fmt.Errorf("%s: %w", path, err)
+	}
+	if token.AccessToken == "" {
+		return time.Time{}, fmt.Errorf("%w: SSO token for profile %q has no access token", errAWSNoCredentials, profile.Name)
+	}
+
+	return parseExpiry(token.ExpiresAt, time.Now())
+}
+
+// findAWSCLICacheExpiry finds cached role credentials for the profile. SSO
+// role credentials are stored under a hash of the account, role and session;
+// assumed-role entries hash the full request, so they are matched on the
+// assumed role ARN and the latest expiry wins.
+func findAWSCLICacheExpiry(cacheDir string, profile *awsProfile) (time.Time, error) {
+	if profile.RoleARN == "" {
+		return findAWSSSORoleCacheExpiry(cacheDir, profile)
+	}
+
+	// arn:aws:iam::123456789012:role/path/RoleName
+	parts := strings.Split(profile.RoleARN, ":")
+	if len(parts) != 6 {
+		return time.Time{}, fmt.Errorf("profile %q has an invalid role_arn %q", profile.Name, profile.RoleARN)
+	}
+	account := parts[4]
+	role := parts[5][strings.LastIndex(parts[5], "/")+1:]
+
+	files, err := filepath.Glob(filepath.Join(cacheDir, "*.json"))
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var latest time.Time
+	for _, path := range files {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var entry awsCLICacheEntry
+		if json.Unmarshal(data, &entry) != nil || entry.Credentials.AccessKeyID == "" {
+			continue
+		}
+
+		// arn:aws:sts::123456789012:assumed-role/RoleName/session
+		arn := entry.AssumedRoleUser.Arn
+		if !strings.Contains(arn, ":"+account+":assumed-role/"+role+"/") {
+			continue
+		}
+
+		expiresAt, err := parseExpiry(entry.Credentials.Expiration, time.Now())
+		if err == nil && expiresAt.After(latest) {
+			latest = expiresAt
+		}
+	}
+
+	if latest.IsZero() {
+		return time.Time{}, fmt.Errorf("%w for profile %q", errAWSNoCredentials, profile.Name)
+	}
+	return latest, nil
+}
+
+// findAWSSSORoleCacheExpiry reads the credentials the AWS CLI cached for an
+// SSO profile's account and role
+func findAWSSSORoleCacheExpiry(cacheDir string, profile *awsProfile) (time.Time, error) {
+	key, err := awsSSORoleCacheKey(profile)
+	if err != nil {
+		return time.Time{}, err
+	}
+	path := filepath.Join(cacheDir, key+".json")
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return time.Time{}, fmt.Errorf("%w for profile %q", errAWSNoCredentials, profile.Name)
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var entry awsCLICacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return time.Time{}, fmt.Errorf("%s: %w", path, err)
+	}
+	if entry.Credentials.AccessKeyID == "" {
+		return time.Time{}, fmt.Errorf("%w for profile %q", errAWSNoCredentials, profile.Name)
+	}
+	return parseExpiry(entry.Credentials.Expiration, time.Now())
+}
+
+// awsSSORoleCacheKey is the file name, without .json, the AWS CLI uses for an
+// SSO profile's role credentials: the SHA-1 of the compact, key-sorted JSON
+// of account, role and either the sso-session name or the legacy start URL.
+func awsSSORoleCacheKey(profile *awsProfile) (string, error) {
+	if profile.SSOAccountID == "" || profile.SSORoleName == "" {
+		return "", fmt.Errorf("profile %q has no role_arn or sso_account_id/sso_role_name", profile.Name)
+	}
+
+	args := map[string]string{
+		"accountId": profile.SSOAccountID,
+		"roleName":  profile.SSORoleName,
+	}
+	if profile.SSOSession != "" {
+		args["sessionName"] = profile.SSOSession
+	} else {
+		args["startUrl"] = profile.SSOStartURL
+	}
+
+	// Maps marshal with sorted keys; keep URLs unescaped like Python's json.dumps
+	var buf strings.Builder
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(args); err != nil {
+		return "", err
+	}
+	sum := sha1.Sum([]byte(strings.TrimSuffix(buf.String(), "\n")))
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// The fixtures in testdata/aws mirror what the AWS CLI writes. Cache file
+// names were computed with botocore's own hashing, so a mismatch here means
+// gatekeeper no longer finds the files the CLI creates.
+const (
+	fixtureAWSConfig   = "testdata/aws/config"
+	fixtureSSOCacheDir = "testdata/aws/sso/cache"
+	fixtureCLICacheDir = "testdata/aws/cli/cache"
+)
+
+func TestLoadAWSProfile(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile string
+		want    awsProfile
+	}{
+		{
+			name:    "sso-session",
+			profile: "prod",
+			want: awsProfile{
+				Name:         "prod",
+				SSOSession:   "my-sso",
+				SSOStartURL:  "https://example.awsapps.com/start",
+				SSOAccountID: "111122223333",
+				SSORoleName:  "AdministratorAccess",
+			},
+		},
+		{
+			name:    "legacy",
+			profile: "legacy",
+			want: awsProfile{
+				Name:         "legacy",
+				SSOStartURL:  "https://legacy.awsapps.com/start",
+				SSOAccountID: "444455556666",
+				SSORoleName:  "ReadOnly",
+			},
+		},
+		{
+			name:    "role_arn with nested settings",
+			profile: "deploy",
+			want: awsProfile{
+				Name:    "deploy",
+				RoleARN: "arn:aws:iam::777788889999:role/ci/Deployer",
+			},
+		},
+		{
+			name:    "default section",
+			profile: "",
+			want:    awsProfile{Name: "default"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := loadAWSProfile(fixtureAWSConfig, tt.profile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *got != tt.want {
+				t.Errorf("got %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseININestedValues(t *testing.T) {
+	sections, err := parseINI(fixtureAWSConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deploy := sections["profile deploy"]
+	if _, ok := deploy["max_concurrent_requests"]; ok {
+		t.Errorf("nested s3 value leaked into the profile: %v", deploy)
+	}
+	if len(deploy) != 3 || deploy["source_profile"] != "prod" {
+		t.Errorf("got profile deploy %v", deploy)
+	}
+}
+
+func TestLoadAWSProfileErrors(t *testing.T) {
+	for _, profile := range []string{"missing", "broken"} {
+		if _, err := loadAWSProfile(fixtureAWSConfig, profile); err == nil {
+			t.Errorf("profile %q: expected an error", profile)
+		}
+	}
+	if _, err := loadAWSProfile(filepath.Join(t.TempDir(), "config"), "prod"); err == nil {
+		t.Error("missing config file: expected an error")
+	}
+}
+
+func TestFindAWSSSOTokenExpiry(t *testing.T) {
+	tests := []struct {
+		profile string
+		want    time.Time
+	}{
+		{"prod", time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)},    // hashed sso-session name
+		{"legacy", time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)}, // hashed start URL
+	}
+
+	for _, tt := range tests {
+		profile, err := loadAWSProfile(fixtureAWSConfig, tt.profile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := findAWSSSOTokenExpiry(fixtureSSOCacheDir, profile)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.profile, err)
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("%s: got %s, want %s", tt.profile, got, tt.want)
+		}
+	}
+
+	// Logged out: no cache file
+	profile, _ := loadAWSProfile(fixtureAWSConfig, "prod")
+	if _, err := findAWSSSOTokenExpiry(t.TempDir(), profile); !errors.Is(err, errAWSNoCredentials) {
+		t.Errorf("empty cache: got %v, want errAWSNoCredentials", err)
+	}
+
+	// Not an SSO profile
+	profile, _ = loadAWSProfile(fixtureAWSConfig, "deploy")
+	if _, err := findAWSSSOTokenExpiry(fixtureSSOCacheDir, profile); err == nil || errors.Is(err, errAWSNoCredentials) {
+		t.Errorf("role_arn profile: got %v, want a config error", err)
+	}
+}
+
+func TestFindAWSCLICacheExpiry(t *testing.T) {
+	tests := []struct {
+		profile string
+		want    time.Time
+	}{
+		// SSO role credentials, stored under the account/role/session hash
+		{"prod", time.Date(2030, 1, 2, 4, 0, 0, 0, time.UTC)},
+		// Latest of two assumed-role entries; DeployerReadOnly doesn't match
+		{"deploy", time.Date(2030, 1, 2, 6, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		profile, err := loadAWSProfile(fixtureAWSConfig, tt.profile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := findAWSCLICacheExpiry(fixtureCLICacheDir, profile)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.profile, err)
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("%s: got %s, want %s", tt.profile, got, tt.want)
+		}
+	}
+
+	// The legacy profile has no cached role credentials
+	profile, _ := loadAWSProfile(fixtureAWSConfig, "legacy")
+	if _, err := findAWSCLICacheExpiry(fixtureCLICacheDir, profile); !errors.Is(err, errAWSNoCredentials) {
+		t.Errorf("legacy: got %v, want errAWSNoCredentials", err)
+	}
+}
+
+func TestAWSSSORoleCacheKey(t *testing.T) {
+	// Expected values from botocore's SSOCredentialFetcher._create_cache_key
+	tests := []struct {
+		profile string
+		want    string
+	}{
+		{"prod", "ab11f6e81545e76b18fa84c7e52c30985e5abc4a"},
+		{"legacy", "b0958e7354fad77382e9ca6e4e37cd391e492b42"},
+	}
+	for _, tt := range tests {
+		profile, err := loadAWSProfile(fixtureAWSConfig, tt.profile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := awsSSORoleCacheKey(profile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.profile, got, tt.want)
+		}
+	}
+}
+
+// TestCheckAWSSSONotRetried checks a logged-out profile through the checker:
+// it is unauthenticated after one attempt, however many retries are set
+func TestCheckAWSSSONotRetried(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("AWS_CONFIG_FILE", "")
+	if err := os.CopyFS(filepath.Join(home, ".aws"), os.DirFS("testdata/aws")); err != nil {
+		t.Fatal(err)
+	}
+
+	checker := NewEnhancedChecker(CheckerOptions{})
+	service := Service{Name: "AWS legacy", Type: ServiceTypeAWSSSO, Profile: "legacy", Retries: 3, RetryDelay: 1}
+	status := checker.CheckWithContext(context.Background(), service)
+	if status.State != StateUnauthenticated {
+		t.Errorf("got state %s (%s), want %s", status.State, status.Error, StateUnauthenticated)
+	}
+	if status.Attempt != 1 {
+		t.Errorf("got %d attempts, want 1", status.Attempt)
+	}
+	if status.ExpiresAt == nil {
+		t.Error("expected the expired token's expiry to be reported")
+	}
+
+	service = Service{Name: "AWS prod", Type: ServiceTypeAWSSSO, Profile: "prod", AWSCache: AWSCacheCLI}
+	if status := checker.CheckWithContext(context.Background(), service); status.State != StateOK {
+		t.Errorf("cli cache: got state %s (%s), want %s", status.State, status.Error, StateOK)
+	}
+}
+
+func TestAWSSSODefaultAuthCmd(t *testing.T) {
+	for profile, want := range map[string]string{
+		"":              "aws sso login",
+		"prod":          "aws sso login --profile prod",
+		"my profile":    "aws sso login --profile 'my profile'",
+		"x; rm -rf ~":   "aws sso login --profile 'x; rm -rf ~'",
+		"it's":          `aws sso login --profile 'it'\''s'`,
+		"$(whoami)":     "aws sso login --profile '$(whoami)'",
+		"team/eu-west1": "aws sso login --profile team/eu-west1",
+	} {
+		config := &Config{Services: []Service{{Name: "AWS", Type: ServiceTypeAWSSSO, Profile: profile}}}
+		applyConfigDefaults(config)
+		if got := config.Services[0].AuthCmd; got != want {
+			t.Errorf("profile %q: got auth_cmd %q, want %q", profile, got, want)
+		}
+	}
+}
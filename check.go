@@ -0,0 +1,34 @@
+package main
+
+// Exit codes of 'gatekeeper check'
+const (
+	ExitOK              = 0 // every checked service is authenticated
+	ExitUnauthenticated = 1 // a check says not logged in, the session expired or a dependency failed
+	ExitCheckError      = 2 // a check couldn't tell (error, timeout), or bad usage or config
+)
+
+// checkExitCode maps results to an exit code. Errors win over
+// unauthenticated and blocked services. Disabled services don't count,
+// and neither do unknown results when ignoreUnknown is set, e.g. checks
+// abandoned by --fail-fast.
+func checkExitCode(statuses []ServiceStatus, ignoreUnknown bool) int {
+	code := ExitOK
+	for _, st := range statuses {
+		switch state := statusState(st); state {
+		case StateOK:
+			if isExpired(st) {
+				code = max(code, ExitUnauthenticated)
+			}
+		case StateUnauthenticated, StateBlocked:
+			code = max(code, ExitUnauthenticated)
+		case StateDisabled:
+		case StateUnknown:
+			if !ignoreUnknown {
+				code = ExitCheckError
+			}
+		default:
+			code = ExitCheckError
+		}
+	}
+	return code
+}
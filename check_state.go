@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// CheckState is the outcome of a service check
+type CheckState string
+
+const (
+	StateOK              CheckState = "ok"              // authenticated
+	StateUnauthenticated CheckState = "unauthenticated" // check ran and says not logged in
+	StateTimeout         CheckState = "timeout"         // check didn't finish in time
+	StateError           CheckState = "error"           // check couldn't run (missing binary, bad config)
+	StateUnknown         CheckState = "unknown"         // no result yet, or the check was interrupted
+	StateBlocked         CheckState = "blocked"         // not checked because a dependency isn't authenticated
+	StateDisabled        CheckState = "disabled"        // service is disabled in config
+)
+
+// checkStates lists every state in display order
+var checkStates = []CheckState{
+	StateOK, StateUnauthenticated, StateTimeout, StateError, StateUnknown, StateBlocked, StateDisabled,
+}
+
+// Exit codes the shell uses when a command can't be run at all
+const (
+	exitNotExecutable = 126
+	exitNotFound      = 127
+)
+
+// stateSymbols are shown in compact and colored output
+var stateSymbols = map[CheckState]string{
+	StateOK:              "✅",
+	StateUnauthenticated: "❌",
+	StateTimeout:         "⏱",
+	StateError:           "⚠",
+	StateUnknown:         "❔",
+	StateBlocked:         "⛔",
+	StateDisabled:        "⏸",
+}
+
+// stateColors are ANSI colors for terminal output
+var stateColors = map[CheckState]string{
+	StateOK:              "\033[32m", // green
+	StateUnauthenticated: "\033[31m", // red
+	StateTimeout:         "\033[33m", // yellow
+	StateError:           "\033[35m", // magenta
+	StateUnknown:         "\033[90m", // gray
+	StateBlocked:         "\033[90m", // gray
+	StateDisabled:        "\033[90m", // gray
+}
+
+func isValidCheckState(s CheckState) bool {
+	return slices.Contains(checkStates, s)
+}
+
+// Symbol returns the display symbol for the state
+func (s CheckState) Symbol() string {
+	if sym, ok := stateSymbols[s]; ok {
+		return sym
+	}
+	return stateSymbols[StateUnknown]
+}
+
+// statusState returns the state of a status, deriving it from is_alive for
+// state files written before the state field existed
+func statusState(s ServiceStatus) CheckState {
+	if s.State != "" {
+		return s.State
+	}
+	if s.IsAlive {
+		return StateOK
+	}
+	return StateUnauthenticated
+}
+
+// classifyExit maps a check_cmd exit code to a state. Mappings in the
+// service's exit_codes win; otherwise 0 is ok, 126/127 mean the command
+// couldn't run and anything else means not authenticated.
+func classifyExit(service Service, exitCode int) CheckState {
+	if state, ok := service.ExitCodes[exitCode]; ok {
+		return state
+	}
+	switch exitCode {
+	case 0:
+		return StateOK
+	case exitNotExecutable, exitNotFound:
+		return StateError
+	}
+	return StateUnauthenticated
+}
+
+// describeFailure returns a short error message for a failed check
+func describeFailure(state CheckState, exitCode int, timeout time.Duration) string {
+	switch state {
+	case StateTimeout:
+		return fmt.Sprintf("check timed out after %s", timeout)
+	case StateError:
+		switch exitCode {
+		case exitNotFound:
+			return "command not found"
+		case exitNotExecutable:
+			return "command not executable"
+		case -1:
+			return "check could not be run"
+		}
+		return fmt.Sprintf("exit code %d", exitCode)
+	case StateUnknown:
+		return "check cancelled"
+	}
+	return fmt.Sprintf("exit code %d", exitCode)
+}
@@ -2,24 +2,53 @@ package main
 
 import (
 	"context"
+	"errors"
+	"io"
+	"math/rand"
 	"os"
 	"os/exec"
+	"slices"
+	"strings"
 	"time"
 )
 
-const DefaultTimeout = 10 * time.Second
+const (
+	DefaultTimeout         = 10 * time.Second
+	DefaultRetryDelay      = 2 * time.Second
+	DefaultRetryMultiplier = 2.0
+	DefaultMaxRetryDelay   = 30 * time.Second
+)
 
 type ServiceStatus struct {
-	Name    string `json:"name"`
-	IsAlive bool   `json:"is_alive"`
-	Error   string `json:"error,omitempty"`
-	Icon    string `json:"icon,omitempty"`
+	Name       string     `json:"name"`
+	State      CheckState `json:"state"`
+	IsAlive    bool       `json:"is_alive"` // same as state == ok, kept for older clients
+	Error      string     `json:"error,omitempty"`
+	Icon       string     `json:"icon,omitempty"`
+	Group      string     `json:"group,omitempty"`
+	Tags       []string   `json:"tags,omitempty"`
+	Attempt    int        `json:"attempt,omitempty"`     // attempt that produced the result
+	DurationMs int64      `json:"duration_ms,omitempty"` // total time spent, including retries
+	ExitCode   *int       `json:"exit_code,omitempty"`   // check_cmd exit code of the last attempt
+	Output     string     `json:"output,omitempty"`      // redacted tail of output when the check failed
+
+	LastChecked time.Time `json:"last_checked"`
+	NextCheck   time.Time `json:"next_check"` // set by the daemon scheduler
+
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+	ExpiryWarning int        `json:"expiry_warning,omitempty"` // seconds before expires_at to warn
 }
 
 type CheckerOptions struct {
-	Timeout time.Duration
-	Retries int
-	Logger  *Logger
+	Timeout          time.Duration
+	Retries          int
+	RetryDelay       time.Duration
+	RetryMultiplier  float64
+	MaxRetryDelay    time.Duration
+	RetryJitter      float64
+	RetryOnExitCodes []int // empty means retry on any failure
+	ExpiryWarning    time.Duration
+	Logger           *Logger
 }
 
 type EnhancedChecker struct {
@@ -33,85 +62,386 @@ func NewEnhancedChecker(opts CheckerOptions) *EnhancedChecker {
 	if opts.Retries == 0 {
 		opts.Retries = 1
 	}
+	if opts.RetryDelay == 0 {
+		opts.RetryDelay = DefaultRetryDelay
+	}
+	if opts.RetryMultiplier == 0 {
+		opts.RetryMultiplier = DefaultRetryMultiplier
+	}
+	if opts.MaxRetryDelay == 0 {
+		opts.MaxRetryDelay = DefaultMaxRetryDelay
+	}
+	if opts.ExpiryWarning == 0 {
+		opts.ExpiryWarning = DefaultExpiryWarning
+	}
 	return &EnhancedChecker{opts: opts}
 }
 
+// serviceOptions returns checker options with per-service overrides applied
+func (c *EnhancedChecker) serviceOptions(service Service) CheckerOptions {
+	opts := c.opts
+	if service.Timeout > 0 {
+		opts.Timeout = time.Duration(service.Timeout) * time.Second
+	}
+	if service.Retries > 0 {
+		opts.Retries = service.Retries
+	}
+	if service.RetryDelay > 0 {
+		opts.RetryDelay = time.Duration(service.RetryDelay) * time.Second
+	}
+	if service.ExpiryWarning > 0 {
+		opts.ExpiryWarning = time.Duration(service.ExpiryWarning) * time.Second
+	}
+	if p := service.Retry; p != nil {
+		if p.Delay > 0 {
+			opts.RetryDelay = secondsToDuration(p.Delay)
+		}
+		if p.Multiplier > 0 {
+			opts.RetryMultiplier = p.Multiplier
+		}
+		if p.MaxDelay > 0 {
+			opts.MaxRetryDelay = secondsToDuration(p.MaxDelay)
+		}
+		if p.Jitter > 0 {
+			opts.RetryJitter = p.Jitter
+		}
+		if len(p.OnExitCodes) > 0 {
+			opts.RetryOnExitCodes = p.OnExitCodes
+		}
+	}
+	return opts
+}
+
+// backoff is an exponential backoff policy
+type backoff struct {
+	Initial    time.Duration // wait after the first attempt
+	Multiplier float64       // each wait is this much longer
+	Max        time.Duration // cap for a single wait
+	Jitter     float64       // randomize each wait by +/- this fraction
+}
+
+// Delay returns the wait before the retry that follows the given attempt.
+// The delay grows by Multiplier per attempt, is capped at Max and then
+// randomized by +/- Jitter.
+func (b backoff) Delay(attempt int) time.Duration {
+	delay := float64(b.Initial)
+	for i := 1; i < attempt; i++ {
+		delay *= b.Multiplier
+		if delay >= float64(b.Max) {
+			break
+		}
+	}
+	delay = min(delay, float64(b.Max))
+
+	if b.Jitter > 0 {
+		jitter := min(b.Jitter, 1)
+		delay *= 1 + jitter*(2*rand.Float64()-1)
+	}
+	return time.Duration(delay)
+}
+
+// backoffDelay returns the wait before the retry that follows the given
+// attempt, using the check's retry policy
+func backoffDelay(opts CheckerOptions, attempt int) time.Duration {
+	return backoff{
+		Initial:    opts.RetryDelay,
+		Multiplier: opts.RetryMultiplier,
+		Max:        opts.MaxRetryDelay,
+		Jitter:     opts.RetryJitter,
+	}.Delay(attempt)
+}
+
+// shouldRetry reports whether a failed attempt is worth repeating.
+// Failures without an exit code (timeouts, start errors) are always retried.
+func shouldRetry(opts CheckerOptions, exitCode int) bool {
+	if len(opts.RetryOnExitCodes) == 0 || exitCode < 0 {
+		return true
+	}
+	return slices.Contains(opts.RetryOnExitCodes, exitCode)
+}
+
+// sleepContext waits for d or until ctx is done, whichever comes first.
+// Returns false if the context was cancelled.
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
 // CheckWithContext executes command with timeout and retry logic
 func (c *EnhancedChecker) CheckWithContext(ctx context.Context, service Service) ServiceStatus {
 	status := ServiceStatus{
-		Name: service.Name,
-		Icon: getServiceIcon(service.Name, service.Icon),
+		Name:  service.Name,
+		Icon:  getServiceIcon(service.Name, service.Icon),
+		Group: service.Group,
+		Tags:  service.Tags,
+		State: StateUnknown,
 	}
 
+	if service.Disabled {
+		status.State = StateDisabled
+		return status
+	}
+
+	opts := c.serviceOptions(service)
+	start := time.Now()
+
 	// Try with retries
-	for attempt := 1; attempt <= c.opts.Retries; attempt++ {
-		if c.executeCheck(ctx, service, &status) {
+	for attempt := 1; attempt <= opts.Retries; attempt++ {
+		status.Attempt = attempt
+		state, exitCode, output := c.executeCheck(ctx, service, opts.Timeout, &status)
+		status.State = state
+		if state == StateOK {
 			status.IsAlive = true
+			status.Error = ""
+			if service.hasExpiry() {
+				c.checkExpiry(ctx, service, opts, output, &status)
+			}
+			if status.ExpiresAt != nil {
+				status.ExpiryWarning = int(opts.ExpiryWarning / time.Second)
+			}
+			status.DurationMs = time.Since(start).Milliseconds()
+			status.LastChecked = time.Now()
 			if c.opts.Logger != nil {
-				c.opts.Logger.Infof("[%s] ✅ check passed (attempt %d/%d)", 
-					service.Name, attempt, c.opts.Retries)
+				c.opts.Logger.Infof("[%s] ✅ check passed (attempt %d/%d, %dms)",
+					service.Name, attempt, opts.Retries, status.DurationMs)
 			}
 			return status
 		}
 
-		if attempt < c.opts.Retries {
+		if attempt >= opts.Retries || state == StateUnknown {
+			break
+		}
+
+		// A command that can't run won't start working on retry, and
+		// native checks would just read the same files again
+		if state == StateError || service.Type == ServiceTypeAWSSSO || !shouldRetry(opts, exitCode) {
 			if c.opts.Logger != nil {
-				c.opts.Logger.Warnf("[%s] check failed, retrying... (attempt %d/%d)", 
-					service.Name, attempt, c.opts.Retries)
+				c.opts.Logger.Warnf("[%s] check failed (%s, exit code %d), not retrying",
+					service.Name, state, exitCode)
 			}
-			time.Sleep(2 * time.Second)
+			break
+		}
+
+		delay := backoffDelay(opts, attempt)
+		if c.opts.Logger != nil {
+			c.opts.Logger.Warnf("[%s] check failed (%s), retrying in %s... (attempt %d/%d)",
+				service.Name, state, delay.Round(time.Millisecond), attempt, opts.Retries)
+		}
+		if !sleepContext(ctx, delay) {
+			status.State = StateUnknown
+			status.Error = describeFailure(StateUnknown, -1, opts.Timeout)
+			break
 		}
 	}
 
 	status.IsAlive = false
+	status.DurationMs = time.Since(start).Milliseconds()
+	status.LastChecked = time.Now()
 	if c.opts.Logger != nil {
-		c.opts.Logger.Errorf("[%s] ❌ check failed after %d attempts", 
-			service.Name, c.opts.Retries)
+		c.opts.Logger.Errorf("[%s] %s check failed: %s after %d attempts (%dms)",
+			service.Name, status.State.Symbol(), status.State, status.Attempt, status.DurationMs)
 	}
 	return status
 }
 
-func (c *EnhancedChecker) executeCheck(ctx context.Context, service Service, status *ServiceStatus) bool {
+// executeCheck runs the check once and returns its state, exit code and stdout
+func (c *EnhancedChecker) executeCheck(ctx context.Context, service Service, timeout time.Duration, status *ServiceStatus) (CheckState, int, []byte) {
+	// Native checks read local files instead of running a command
+	if service.Type == ServiceTypeAWSSSO {
+		state, expiresAt, err := checkAWSSSO(service, time.Now())
+		status.ExpiresAt = expiresAt
+		if err != nil {
+			status.Error = err.Error()
+		}
+		return state, -1, nil
+	}
+
 	// Run check_cmd
-	if service.CheckCmd != "" {
-		if c.runCommand(ctx, service.CheckCmd) {
-			return true
+	if service.CheckCmd == "" {
+		status.Error = "no check_cmd configured"
+		return StateError, -1, nil
+	}
+
+	result, err := c.runCommand(ctx, service.CheckCmd, timeout)
+	exitCode := result.ExitCode
+	var state CheckState
+	switch {
+	case errors.Is(err, errCheckTimeout):
+		state = StateTimeout
+	case ctx.Err() != nil:
+		state = StateUnknown
+	case exitCode < 0:
+		state = StateError
+	default:
+		state = classifyExit(service, exitCode)
+	}
+
+	status.ExitCode = nil
+	status.Output = ""
+	if exitCode >= 0 {
+		status.ExitCode = &exitCode
+	}
+	if state != StateOK {
+		status.Error = describeFailure(state, exitCode, timeout)
+		status.Output = summarizeOutput(result.Output)
+	}
+	return state, exitCode, result.Stdout
+}
+
+// checkExpiry records when the service's credentials expire.
+// Failures are logged but don't affect the check result.
+func (c *EnhancedChecker) checkExpiry(ctx context.Context, service Service, opts CheckerOptions, checkOutput []byte, status *ServiceStatus) {
+	output := checkOutput
+	if service.ExpiryCmd != "" {
+		result, err := c.runCommand(ctx, service.ExpiryCmd, opts.Timeout)
+		output = result.Stdout
+		if err != nil {
+			if c.opts.Logger != nil {
+				c.opts.Logger.Warnf("[%s] expiry_cmd failed: %v", service.Name, err)
+			}
+			return
 		}
-		status.Error = "check failed"
 	}
 
-	return false
+	expiresAt, err := extractExpiry(service, output, time.Now())
+	if err != nil {
+		if c.opts.Logger != nil {
+			c.opts.Logger.Warnf("[%s] could not determine expiry: %v", service.Name, err)
+		}
+		return
+	}
+
+	status.ExpiresAt = &expiresAt
 }
 
-func (c *EnhancedChecker) runCommand(ctx context.Context, cmdStr string) bool {
-	ctx, cancel := context.WithTimeout(ctx, c.opts.Timeout)
+// commandResult is what runCommand captured from a command
+type commandResult struct {
+	Stdout   []byte // start of stdout, for expiry extraction
+	Output   string // end of combined stdout and stderr
+	ExitCode int    // -1 when the command did not exit on its own (timeout, start failure)
+}
+
+// runCommand executes cmdStr through bash and captures its output and exit code
+func (c *EnhancedChecker) runCommand(parent context.Context, cmdStr string, timeout time.Duration) (commandResult, error) {
+	ctx, cancel := context.WithTimeout(parent, timeout)
 	defer cancel()
 
 	// Expand environment variables
 	cmdStr = os.ExpandEnv(cmdStr)
 
+	stdout := &cappedBuffer{max: maxCommandOutput}
+	tail := &tailBuffer{max: maxOutputTailBytes}
 	cmd := exec.CommandContext(ctx, "bash", "-c", cmdStr)
-	cmd.Stdout = nil
-	cmd.Stderr = nil
+	cmd.Stdout = io.MultiWriter(stdout, tail)
+	cmd.Stderr = tail
+
+	err := cmd.Run()
+	result := commandResult{Stdout: stdout.Bytes(), Output: tail.String(), ExitCode: -1}
+	if err == nil {
+		result.ExitCode = 0
+		return result, nil
+	}
+
+	// Our own deadline passed; a cancelled parent context is not a timeout
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) && parent.Err() == nil {
+		return result, errCheckTimeout
+	}
 
-	return cmd.Run() == nil
+	var exitErr *exec.ExitError
+	if ctx.Err() == nil && errors.As(err, &exitErr) {
+		result.ExitCode = exitErr.ExitCode()
+	}
+	return result, err
 }
 
+// errCheckTimeout is returned by runCommand when the command ran out of time
+var errCheckTimeout = errors.New("command timed out")
+
 // CheckBatch runs multiple checks concurrently
 func (c *EnhancedChecker) CheckBatch(ctx context.Context, services []Service) []ServiceStatus {
+	return c.CheckBatchFunc(ctx, services, nil)
+}
+
+// CheckBatchFunc is CheckBatch that also calls onResult, if set, as each
+// check finishes. Calls happen one at a time in completion order, so
+// onResult can cancel ctx to abandon the remaining checks.
+//
+// A service waits for the services in the batch it depends on and is
+// blocked if one of them isn't authenticated. Services in or depending on
+// a dependency cycle aren't checked.
+func (c *EnhancedChecker) CheckBatchFunc(ctx context.Context, services []Service, onResult func(ServiceStatus)) []ServiceStatus {
 	results := make([]ServiceStatus, len(services))
 	done := make(chan int, len(services))
+	finished := make([]chan struct{}, len(services))
+	for i := range finished {
+		finished[i] = make(chan struct{})
+	}
+
+	// Validation rejects cycles, but a leniently loaded config may have
+	// some. Services in or behind a cycle would wait on each other forever.
+	var unplaced []string
+	var cycleErr *dependencyCycleError
+	if _, err := dependencyOrder(services); errors.As(err, &cycleErr) {
+		unplaced = cycleErr.Unplaced
+	}
 
+	index := indexByName(services)
 	for i, svc := range services {
 		go func(idx int, s Service) {
+			defer func() { done <- idx }()
+			defer close(finished[idx])
+
+			if slices.Contains(unplaced, s.Name) {
+				results[idx] = ServiceStatus{
+					Name:        s.Name,
+					Icon:        getServiceIcon(s.Name, s.Icon),
+					Group:       s.Group,
+					Tags:        s.Tags,
+					State:       StateError,
+					Error:       cycleErr.Error(),
+					LastChecked: time.Now(),
+				}
+				return
+			}
+
+			if !s.Disabled {
+				for _, dep := range s.DependsOn {
+					j, ok := index[strings.ToLower(dep)]
+					if !ok {
+						continue
+					}
+					<-finished[j]
+					parent := results[j]
+					if statusState(parent) != StateDisabled && !isAuthenticated(parent) {
+						results[idx] = blockedStatus(s, parent)
+						if c.opts.Logger != nil {
+							c.opts.Logger.Warnf("[%s] %s blocked: %s", s.Name, StateBlocked.Symbol(), results[idx].Error)
+						}
+						return
+					}
+				}
+			}
 			results[idx] = c.CheckWithContext(ctx, s)
-			done <- idx
 		}(i, svc)
 	}
 
 	// Wait for all checks to complete
 	for range services {
-		<-done
+		idx := <-done
+		if onResult != nil {
+			onResult(results[idx])
+		}
 	}
 
 	return results
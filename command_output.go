@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const (
+	// maxCommandOutput bounds how much stdout is kept for expiry extraction
+	maxCommandOutput = 1 << 20
+
+	// Limits for the output tail stored in state.json
+	maxOutputTailBytes = 4096
+	maxOutputTailLines = 10
+	maxOutputTailChars = 1000
+)
+
+// cappedBuffer keeps the first max bytes written and discards the rest
+type cappedBuffer struct {
+	bytes.Buffer
+	max int
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.Len(); room > 0 {
+		if len(p) > room {
+			b.Buffer.Write(p[:room])
+		} else {
+			b.Buffer.Write(p)
+		}
+	}
+	// Report everything as written so the command isn't killed by EPIPE
+	return len(p), nil
+}
+
+// tailBuffer keeps the last max bytes written. It is shared by stdout and
+// stderr, which exec copies from separate goroutines, so writes are locked.
+type tailBuffer struct {
+	mu        sync.Mutex
+	buf       []byte
+	max       int
+	truncated bool
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.buf = append(b.buf, p...)
+	if over := len(b.buf) - b.max; over > 0 {
+		b.buf = append(b.buf[:0], b.buf[over:]...)
+		b.truncated = true
+	}
+	return len(p), nil
+}
+
+// String returns the complete lines kept. Once the start has been cut off
+// the first line is partial and may have lost the key, such as "token=",
+// that redactSecrets needs to recognize the secret after it, so it is left out.
+func (b *tailBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	out := b.buf
+	if b.truncated {
+		if i := bytes.IndexByte(out, '\n'); i >= 0 {
+			out = out[i+1:]
+		} else {
+			out = nil
+		}
+	}
+	return string(out)
+}
+
+// secretPatterns match credentials commonly printed by CLI tools
+var secretPatterns = []*regexp.Regexp{
+	// key=value and key: value pairs with sensitive names
+	regexp.MustCompile(`(?i)((?:token|secret|password|passwd|api[_-]?key|access[_-]?key|session[_-]?key|credential)[\w-]*["']?\s*[:=]\s*["']?)[^\s"',]+`),
+	// Authorization headers
+	regexp.MustCompile(`(?i)(bearer\s+)[A-Za-z0-9._~+/-]+=*`),
+	// AWS access key IDs
+	regexp.MustCompile(`\b((?:AKIA|ASIA))[A-Z0-9]{16}\b`),
+	// GitHub tokens
+	regexp.MustCompile(`\b(gh[pousr]_)[A-Za-z0-9]{20,}\b`),
+	// JWTs
+	regexp.MustCompile(`\b(eyJ)[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+`),
+	// Long opaque strings (base64/hex secrets)
+	regexp.MustCompile(`()\b[A-Za-z0-9+/_-]{40,}={0,2}`),
+}
+
+// redactSecrets replaces likely credentials, keeping the recognizable prefix
+func redactSecrets(s string) string {
+	for _, re := range secretPatterns {
+		s = re.ReplaceAllString(s, "${1}[REDACTED]")
+	}
+	return s
+}
+
+// summarizeOutput returns the redacted last lines of command output,
+// bounded for storage in state.json
+func summarizeOutput(raw string) string {
+	raw = strings.ToValidUTF8(raw, "")
+	lines := strings.Split(strings.TrimRight(raw, "\n"), "\n")
+	if len(lines) > maxOutputTailLines {
+		lines = lines[len(lines)-maxOutputTailLines:]
+	}
+
+	out := redactSecrets(strings.TrimSpace(strings.Join(lines, "\n")))
+	if runes := []rune(out); len(runes) > maxOutputTailChars {
+		out = "…" + string(runes[len(runes)-maxOutputTailChars:])
+	}
+	return out
+}
@@ -7,17 +7,127 @@ import (
 )
 
 type Service struct {
-	Name     string `yaml:"name"`
-	CheckCmd string `yaml:"check_cmd"`
-	AuthCmd  string `yaml:"auth_cmd"`
-	Timeout  int    `yaml:"timeout"`  // seconds
-	Retries  int    `yaml:"retries"`
-	Icon     string `yaml:"icon"`     // optional custom icon for tmux display
+	Name       string       `yaml:"name"`
+	Type       string       `yaml:"type"` // command (default) or aws_sso
+	Disabled   bool         `yaml:"disabled"`
+	CheckCmd   string       `yaml:"check_cmd"`
+	AuthCmd    string       `yaml:"auth_cmd"`
+	Timeout    int          `yaml:"timeout"` // seconds
+	Retries    int          `yaml:"retries"`
+	RetryDelay int          `yaml:"retry_delay"` // seconds before the first retry
+	Retry      *RetryPolicy `yaml:"retry"`       // optional backoff tuning
+	Interval   int          `yaml:"interval"`    // seconds; overrides the global interval
+	Icon       string       `yaml:"icon"`        // optional custom icon for tmux display
+
+	// Selected with @group (or group:name) and tag:name wherever
+	// service names are accepted
+	Group string   `yaml:"group"`
+	Tags  []string `yaml:"tags"`
+
+	// Services that must be authenticated before this one is checked;
+	// while one isn't, this service is blocked instead of failing
+	DependsOn []string `yaml:"depends_on"`
+
+	// Map check_cmd exit codes to states, e.g. {1: unauthenticated, 2: error}
+	ExitCodes map[int]CheckState `yaml:"exit_codes"`
+
+	// Optional credential expiry tracking. expiry_cmd prints the expiry time;
+	// expiry_regex or expiry_json_path extract it from expiry_cmd output,
+	// or from check_cmd output when there is no expiry_cmd.
+	ExpiryCmd      string `yaml:"expiry_cmd"`
+	ExpiryRegex    string `yaml:"expiry_regex"`
+	ExpiryJSONPath string `yaml:"expiry_json_path"`
+	ExpiryWarning  int    `yaml:"expiry_warning"` // seconds before expiry to warn (default: global)
+
+	// type: aws_sso
+	Profile  string `yaml:"profile"`   // AWS profile name (default: default)
+	AWSCache string `yaml:"aws_cache"` // sso (default) or cli for assumed-role credentials
+
+	// Alerting: severity is info, warning (default) or critical;
+	// critical: true is shorthand for severity: critical
+	Severity string `yaml:"severity"`
+	Critical bool   `yaml:"critical"`
+
+	// Commands run when this service changes state, after the global ones
+	Hooks `yaml:",inline"`
+}
+
+// NotificationsConfig controls desktop notifications
+type NotificationsConfig struct {
+	Desktop   bool   `yaml:"desktop"`    // notify through org.freedesktop.Notifications
+	Recover   bool   `yaml:"recover"`    // also notify when a service is authenticated again
+	RateLimit int    `yaml:"rate_limit"` // minimum seconds between notifications per service and event (default: 300)
+	Terminal  string `yaml:"terminal"`   // run the re-authenticate action in this terminal, e.g. "kitty --"
+}
+
+// NotifierConfig is an HTTP endpoint that receives alerts
+type NotifierConfig struct {
+	Name        string            `yaml:"name"`
+	Type        string            `yaml:"type"` // webhook, slack or smtp
+	URL         string            `yaml:"url"`  // environment variables are expanded
+	Headers     map[string]string `yaml:"headers"`
+	Template    string            `yaml:"template"`     // Go template for the request body
+	MinSeverity string            `yaml:"min_severity"` // only alert for services at least this severe (default: warning)
+	Events      []string          `yaml:"events"`       // fail, recover, expiring (default: all)
+	Timeout     int               `yaml:"timeout"`      // seconds per request (default: 10)
+	MaxAttempts int               `yaml:"max_attempts"` // delivery attempts before an alert is dropped (default: 10)
+	SMTP        *SMTPConfig       `yaml:"smtp"`         // type: smtp
+}
+
+// Hooks are shell commands run when a service changes state.
+// They can be set globally and per service.
+type Hooks struct {
+	OnFail       string `yaml:"on_fail"`       // was ok, now failing
+	OnRecover    string `yaml:"on_recover"`    // was failing, now ok
+	OnChange     string `yaml:"on_change"`     // any state change
+	HookDebounce int    `yaml:"hook_debounce"` // results in a row before a change counts (default: 2)
+	HookTimeout  int    `yaml:"hook_timeout"`  // seconds (default: 30)
+}
+
+// RetryPolicy controls the backoff between check attempts.
+// Delays are in seconds and may be fractional (e.g. 0.5).
+type RetryPolicy struct {
+	Delay       float64 `yaml:"delay"`         // wait before the first retry (overrides retry_delay)
+	Multiplier  float64 `yaml:"multiplier"`    // growth factor per attempt (default: 2)
+	MaxDelay    float64 `yaml:"max_delay"`     // upper bound for a single wait (default: 30)
+	Jitter      float64 `yaml:"jitter"`        // randomize each wait by +/- this fraction (0-1)
+	OnExitCodes []int   `yaml:"on_exit_codes"` // only retry when check_cmd exits with one of these
 }
 
 type Config struct {
-	Services []Service `yaml:"services"`
-	Interval int       `yaml:"interval"` // seconds
+	Services    []Service `yaml:"services"`
+	Interval    int       `yaml:"interval"`     // seconds
+	WatchConfig bool      `yaml:"watch_config"` // reload automatically when the file changes
+
+	ExpiryWarning int `yaml:"expiry_warning"` // seconds before expiry to warn (default: 900)
+
+	History       HistoryConfig       `yaml:"history"`
+	Notifications NotificationsConfig `yaml:"notifications"`
+	Notifiers     []NotifierConfig    `yaml:"notifiers"`
+	Metrics       MetricsConfig       `yaml:"metrics"`
+	API           APIConfig           `yaml:"api"`
+
+	// Commands run when any service changes state
+	Hooks `yaml:",inline"`
+}
+
+// HistoryConfig controls the check history kept in ~/.cache/gatekeeper
+type HistoryConfig struct {
+	Disabled   bool `yaml:"disabled"`
+	AllResults bool `yaml:"all_results"` // record every check, not just state changes
+	MaxSizeKB  int  `yaml:"max_size_kb"` // rotate the file after this size (default: 1024)
+}
+
+// MetricsConfig controls the Prometheus exporter
+type MetricsConfig struct {
+	Listen   string `yaml:"listen"`   // serve /metrics on this address, e.g. 127.0.0.1:9876
+	Textfile bool   `yaml:"textfile"` // write gatekeeper.prom next to state.json after each check
+}
+
+// APIConfig controls the HTTP API
+type APIConfig struct {
+	Listen string `yaml:"listen"` // host:port, or unix:/path for a Unix socket
+	Token  string `yaml:"token"`  // required for TCP; environment variables are expanded
 }
 
 func loadConfig(path string) (*Config, error) {
@@ -31,17 +141,49 @@ func loadConfig(path string) (*Config, error) {
 		return nil, err
 	}
 
+	applyConfigDefaults(&config)
+	return &config, nil
+}
+
+// Allowed range for check intervals, in seconds
+const (
+	minInterval = 5
+	maxInterval = 3600
+)
+
+// applyConfigDefaults fills in defaults and clamps values into range
+func applyConfigDefaults(config *Config) {
 	// Default interval if not specified
 	if config.Interval == 0 {
 		config.Interval = 30
 	}
 
 	// Validate interval bounds
-	if config.Interval < 5 {
-		config.Interval = 5
-	} else if config.Interval > 3600 {
-		config.Interval = 3600
+	config.Interval = clampInterval(config.Interval)
+
+	for i := range config.Services {
+		svc := &config.Services[i]
+
+		// Per-service intervals are optional; 0 means use the global one
+		if svc.Interval != 0 {
+			svc.Interval = clampInterval(svc.Interval)
+		}
+
+		// Native AWS checks know how to log in
+		if svc.Type == ServiceTypeAWSSSO && svc.AuthCmd == "" {
+			svc.AuthCmd = "aws sso login"
+			if svc.Profile != "" {
+				svc.AuthCmd += " --profile " + shellQuote(svc.Profile)
+			}
+		}
 	}
+}
 
-	return &config, nil
+func clampInterval(seconds int) int {
+	if seconds < minInterval {
+		return minInterval
+	} else if seconds > maxInterval {
+		return maxInterval
+	}
+	return seconds
 }
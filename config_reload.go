@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"time"
+)
+
+// configPollInterval is how often the daemon checks the config file for changes
+const configPollInterval = 2 * time.Second
+
+// loadDaemonConfig loads a config file strictly. In lenient mode validation
+// problems are returned as warnings and the file is loaded the old way.
+func loadDaemonConfig(path string, lenient bool) (*Config, *ConfigError, error) {
+	config, err := loadConfigStrict(path)
+	if err == nil {
+		return config, nil, nil
+	}
+
+	var cfgErr *ConfigError
+	if !lenient || !errors.As(err, &cfgErr) {
+		return nil, nil, err
+	}
+
+	config, err = loadConfig(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return config, cfgErr, nil
+}
+
+// ConfigDiff lists services that differ between two configs
+type ConfigDiff struct {
+	Added    []string
+	Removed  []string
+	Changed  []string
+	Interval bool // interval changed
+}
+
+func (d ConfigDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0 && !d.Interval
+}
+
+// diffConfigs compares services by name, in the order they appear
+func diffConfigs(oldConfig, newConfig *Config) ConfigDiff {
+	var diff ConfigDiff
+
+	oldByName := make(map[string]Service, len(oldConfig.Services))
+	for _, svc := range oldConfig.Services {
+		oldByName[svc.Name] = svc
+	}
+	newByName := make(map[string]bool, len(newConfig.Services))
+
+	for _, svc := range newConfig.Services {
+		newByName[svc.Name] = true
+		prev, ok := oldByName[svc.Name]
+		if !ok {
+			diff.Added = append(diff.Added, svc.Name)
+		} else if !reflect.DeepEqual(prev, svc) {
+			diff.Changed = append(diff.Changed, svc.Name)
+		}
+	}
+	for _, svc := range oldConfig.Services {
+		if !newByName[svc.Name] {
+			diff.Removed = append(diff.Removed, svc.Name)
+		}
+	}
+
+	diff.Interval = oldConfig.Interval != newConfig.Interval
+	return diff
+}
+
+// configWatcher detects config file changes by polling its mtime and size.
+// Polling avoids platform specific file notification APIs.
+type configWatcher struct {
+	path    string
+	modTime time.Time
+	size    int64
+}
+
+func newConfigWatcher(path string) *configWatcher {
+	w := &configWatcher{path: path}
+	w.Changed()
+	return w
+}
+
+// Changed reports whether the file was modified since the last call
+func (w *configWatcher) Changed() bool {
+	info, err := os.Stat(w.path)
+	if err != nil {
+		// Editors may briefly remove the file while saving
+		return false
+	}
+
+	changed := !info.ModTime().Equal(w.modTime) || info.Size() != w.size
+	w.modTime = info.ModTime()
+	w.size = info.Size()
+	return changed
+}
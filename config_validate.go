@@ -0,0 +1,533 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigIssue is a single problem found while validating a config file
+type ConfigIssue struct {
+	File    string
+	Line    int
+	Column  int
+	Message string
+}
+
+func (i ConfigIssue) String() string {
+	return fmt.Sprintf("%s:%d:%d: %s", i.File, i.Line, i.Column, i.Message)
+}
+
+// ConfigError collects every issue found in a config file
+type ConfigError struct {
+	Issues []ConfigIssue
+}
+
+func (e *ConfigError) Error() string {
+	lines := make([]string, len(e.Issues))
+	for i, issue := range e.Issues {
+		lines[i] = issue.String()
+	}
+	return fmt.Sprintf("%d problem(s) in config:\n  %s", len(e.Issues), strings.Join(lines, "\n  "))
+}
+
+var (
+	yamlLineRe      = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+	yamlFieldRe     = regexp.MustCompile(`^field (\S+) not found in type`)
+	yamlValueRe     = regexp.MustCompile("`([^`]*)`")
+	yamlDupKeyRe    = regexp.MustCompile(`^mapping key "([^"]*)" already defined`)
+	yamlMsgPrefixRe = regexp.MustCompile(`^yaml: `)
+)
+
+// loadConfigStrict reads and validates a config file.
+// Unknown keys, bad types and semantic problems are all reported together
+// as a *ConfigError; other errors (missing file, syntax) are returned as-is.
+func loadConfigStrict(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config, issues, err := validateConfig(path, data)
+	if err != nil {
+		return nil, err
+	}
+	if len(issues) > 0 {
+		return nil, &ConfigError{Issues: issues}
+	}
+
+	applyConfigDefaults(config)
+	return config, nil
+}
+
+// validateConfig decodes data strictly and returns the decoded config
+// together with every problem found. A syntax error is reported as a
+// single issue since nothing after it can be trusted.
+func validateConfig(file string, data []byte) (*Config, []ConfigIssue, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, []ConfigIssue{yamlSyntaxIssue(file, err)}, nil
+	}
+
+	var config Config
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+
+	var issues []ConfigIssue
+	if err := dec.Decode(&config); err != nil {
+		var typeErr *yaml.TypeError
+		switch {
+		case errors.As(err, &typeErr):
+			for _, msg := range typeErr.Errors {
+				issues = append(issues, yamlTypeIssue(file, &root, msg))
+			}
+		case errors.Is(err, io.EOF):
+			// Empty file; reported below as missing services
+		default:
+			return nil, []ConfigIssue{yamlSyntaxIssue(file, err)}, nil
+		}
+	}
+
+	v := &configValidator{file: file}
+	v.check(&root, &config)
+	issues = append(issues, v.issues...)
+
+	// Report in file order
+	sort.SliceStable(issues, func(i, j int) bool {
+		if issues[i].Line != issues[j].Line {
+			return issues[i].Line < issues[j].Line
+		}
+		return issues[i].Column < issues[j].Column
+	})
+
+	return &config, issues, nil
+}
+
+// yamlSyntaxIssue converts a yaml parse error into an issue.
+// The parser only reports lines, so the column points at the line start.
+func yamlSyntaxIssue(file string, err error) ConfigIssue {
+	msg := yamlMsgPrefixRe.ReplaceAllString(err.Error(), "")
+	issue := ConfigIssue{File: file, Line: 1, Column: 1, Message: msg}
+	if m := yamlLineRe.FindStringSubmatch(err.Error()); m != nil {
+		issue.Line, _ = strconv.Atoi(m[1])
+		issue.Message = m[2]
+	}
+	return issue
+}
+
+// yamlTypeIssue converts one yaml.TypeError entry into an issue and
+// looks up the column of the offending key or value in the node tree
+func yamlTypeIssue(file string, root *yaml.Node, msg string) ConfigIssue {
+	issue := ConfigIssue{File: file, Line: 1, Column: 1, Message: msg}
+	m := yamlLineRe.FindStringSubmatch(msg)
+	if m == nil {
+		return issue
+	}
+	issue.Line, _ = strconv.Atoi(m[1])
+	issue.Message = m[2]
+
+	want := ""
+	if f := yamlFieldRe.FindStringSubmatch(m[2]); f != nil {
+		want = f[1]
+		issue.Message = fmt.Sprintf("unknown field %q", f[1])
+	} else if d := yamlDupKeyRe.FindStringSubmatch(m[2]); d != nil {
+		want = d[1]
+	} else if val := yamlValueRe.FindStringSubmatch(m[2]); val != nil {
+		want = val[1]
+	}
+
+	if node := findNodeOnLine(root, issue.Line, want); node != nil {
+		issue.Column = node.Column
+	}
+	return issue
+}
+
+// findNodeOnLine returns the first node on line whose value is want,
+// or the first node on that line if none matches
+func findNodeOnLine(node *yaml.Node, line int, want string) *yaml.Node {
+	var first *yaml.Node
+	var walk func(n *yaml.Node) *yaml.Node
+	walk = func(n *yaml.Node) *yaml.Node {
+		if n.Line == line {
+			if n.Kind == yaml.ScalarNode && n.Value == want {
+				return n
+			}
+			if first == nil {
+				first = n
+			}
+		}
+		for _, child := range n.Content {
+			if found := walk(child); found != nil {
+				return found
+			}
+		}
+		return nil
+	}
+	if found := walk(node); found != nil {
+		return found
+	}
+	return first
+}
+
+// mappingValue returns the value node for key in a mapping node, or nil
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// configValidator runs semantic checks on a decoded config and uses the
+// node tree to attach positions to each problem
+type configValidator struct {
+	file   string
+	issues []ConfigIssue
+}
+
+func (v *configValidator) addf(node *yaml.Node, format string, args ...interface{}) {
+	issue := ConfigIssue{File: v.file, Line: 1, Column: 1, Message: fmt.Sprintf(format, args...)}
+	if node != nil {
+		issue.Line = node.Line
+		issue.Column = node.Column
+	}
+	v.issues = append(v.issues, issue)
+}
+
+// at returns the value node for key, falling back to the parent node
+// so fields that are missing entirely still get a sensible position
+func at(parent *yaml.Node, key string) *yaml.Node {
+	if n := mappingValue(parent, key); n != nil {
+		return n
+	}
+	return parent
+}
+
+func (v *configValidator) check(root *yaml.Node, config *Config) {
+	var doc *yaml.Node
+	if root.Kind == yaml.DocumentNode && len(root.Content) > 0 {
+		doc = root.Content[0]
+	}
+
+	if config.Interval != 0 && clampInterval(config.Interval) != config.Interval {
+		v.addf(at(doc, "interval"), "interval must be between %d and %d seconds, got %d", minInterval, maxInterval, config.Interval)
+	}
+
+	if config.ExpiryWarning < 0 {
+		v.addf(at(doc, "expiry_warning"), "expiry_warning must not be negative")
+	}
+
+	v.checkHooks(doc, config.Hooks, "")
+
+	if config.Notifications.RateLimit < 0 {
+		v.addf(at(mappingValue(doc, "notifications"), "rate_limit"), "notifications.rate_limit must not be negative")
+	}
+
+	v.checkNotifiers(mappingValue(doc, "notifiers"), config.Notifiers)
+
+	if config.History.MaxSizeKB < 0 {
+		v.addf(at(mappingValue(doc, "history"), "max_size_kb"), "history.max_size_kb must not be negative")
+	}
+
+	if addr := config.Metrics.Listen; addr != "" {
+		if _, port, err := net.SplitHostPort(addr); err != nil || port == "" {
+			v.addf(at(mappingValue(doc, "metrics"), "listen"), "metrics.listen must be host:port, got %q", addr)
+		}
+	}
+
+	if addr := config.API.Listen; addr != "" {
+		apiNode := mappingValue(doc, "api")
+		if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+			if path == "" {
+				v.addf(at(apiNode, "listen"), "api.listen needs a socket path after unix:")
+			}
+		} else if _, port, err := net.SplitHostPort(addr); err != nil || port == "" {
+			v.addf(at(apiNode, "listen"), "api.listen must be host:port or unix:/path, got %q", addr)
+		} else if config.API.Token == "" {
+			v.addf(at(apiNode, "listen"), "api.token is required when api.listen is a TCP address")
+		} else if !isLoopbackListen(addr) {
+			v.addf(at(apiNode, "listen"), "api.listen must be a loopback address such as 127.0.0.1:9877 or a Unix socket, got %q", addr)
+		}
+	}
+
+	if len(config.Services) == 0 {
+		v.addf(at(doc, "services"), "no services defined")
+		return
+	}
+
+	var items []*yaml.Node
+	if seq := mappingValue(doc, "services"); seq != nil && seq.Kind == yaml.SequenceNode {
+		items = seq.Content
+	}
+
+	seen := make(map[string]int) // lowercased name -> line
+	for i, svc := range config.Services {
+		var item *yaml.Node
+		if i < len(items) {
+			item = items[i]
+		}
+		v.checkService(item, svc, seen)
+	}
+
+	v.checkDependencies(items, config.Services)
+}
+
+// checkDependencies checks that depends_on names existing services and
+// that no services depend on each other in a loop
+func (v *configValidator) checkDependencies(items []*yaml.Node, services []Service) {
+	index := indexByName(services)
+	for i, svc := range services {
+		var item *yaml.Node
+		if i < len(items) {
+			item = items[i]
+		}
+		for _, dep := range svc.DependsOn {
+			if strings.EqualFold(dep, svc.Name) {
+				v.addf(at(item, "depends_on"), "service %q: depends_on lists the service itself", svc.Name)
+			} else if _, ok := index[strings.ToLower(dep)]; !ok {
+				v.addf(at(item, "depends_on"), "service %q: depends_on names unknown service %q", svc.Name, dep)
+			}
+		}
+	}
+
+	// A service depending on itself is reported above
+	var cycleErr *dependencyCycleError
+	if _, err := dependencyOrder(services); !errors.As(err, &cycleErr) {
+		return
+	}
+	for _, path := range cycleErr.Cycles {
+		if len(path) <= 2 {
+			continue
+		}
+		i := index[strings.ToLower(path[0])]
+		var item *yaml.Node
+		if i < len(items) {
+			item = items[i]
+		}
+		v.addf(at(item, "depends_on"), "dependency cycle: %s", strings.Join(path, " -> "))
+	}
+}
+
+func (v *configValidator) checkService(item *yaml.Node, svc Service, seen map[string]int) {
+	label := svc.Name
+	if strings.TrimSpace(svc.Name) == "" {
+		v.addf(at(item, "name"), "service name must not be empty")
+		label = "<unnamed>"
+	} else {
+		key := strings.ToLower(svc.Name)
+		if prev, ok := seen[key]; ok {
+			v.addf(at(item, "name"), "duplicate service name %q (first defined at line %d)", svc.Name, prev)
+		} else if n := at(item, "name"); n != nil {
+			seen[key] = n.Line
+		} else {
+			seen[key] = 0
+		}
+	}
+
+	switch svc.Type {
+	case "", ServiceTypeCommand:
+		if strings.TrimSpace(svc.CheckCmd) == "" {
+			v.addf(at(item, "check_cmd"), "service %q: check_cmd must not be empty", label)
+		}
+	case ServiceTypeAWSSSO:
+		if svc.AWSCache != "" && svc.AWSCache != AWSCacheSSO && svc.AWSCache != AWSCacheCLI {
+			v.addf(at(item, "aws_cache"), "service %q: aws_cache must be %q or %q", label, AWSCacheSSO, AWSCacheCLI)
+		}
+	default:
+		v.addf(at(item, "type"), "service %q: unknown type %q (expected %q or %q)", label, svc.Type, ServiceTypeCommand, ServiceTypeAWSSSO)
+	}
+	if svc.Type != ServiceTypeAWSSSO && (svc.Profile != "" || svc.AWSCache != "") {
+		v.addf(at(item, "profile"), "service %q: profile and aws_cache only apply to type %q", label, ServiceTypeAWSSSO)
+	}
+	if svc.Timeout < 0 {
+		v.addf(at(item, "timeout"), "service %q: timeout must not be negative", label)
+	}
+	if svc.Interval != 0 && clampInterval(svc.Interval) != svc.Interval {
+		v.addf(at(item, "interval"), "service %q: interval must be between %d and %d seconds, got %d", label, minInterval, maxInterval, svc.Interval)
+	}
+	if svc.Retries < 0 {
+		v.addf(at(item, "retries"), "service %q: retries must not be negative", label)
+	}
+	if svc.RetryDelay < 0 {
+		v.addf(at(item, "retry_delay"), "service %q: retry_delay must not be negative", label)
+	}
+
+	for code, state := range svc.ExitCodes {
+		if code < 0 || code > 255 {
+			v.addf(at(item, "exit_codes"), "service %q: exit code %d is out of range 0-255", label, code)
+		}
+		if !isValidCheckState(state) || state == StateDisabled || state == StateBlocked {
+			v.addf(at(item, "exit_codes"), "service %q: exit code %d maps to unknown state %q", label, code, state)
+		}
+	}
+
+	if svc.ExpiryRegex != "" {
+		if _, err := regexp.Compile(svc.ExpiryRegex); err != nil {
+			v.addf(at(item, "expiry_regex"), "service %q: invalid expiry_regex: %v", label, err)
+		}
+		if svc.ExpiryJSONPath != "" {
+			v.addf(at(item, "expiry_json_path"), "service %q: use either expiry_regex or expiry_json_path, not both", label)
+		}
+	}
+	if svc.ExpiryWarning < 0 {
+		v.addf(at(item, "expiry_warning"), "service %q: expiry_warning must not be negative", label)
+	}
+
+	v.checkHooks(item, svc.Hooks, fmt.Sprintf("service %q: ", label))
+
+	if svc.Severity != "" && !slices.Contains(severities, svc.Severity) {
+		v.addf(at(item, "severity"), "service %q: severity must be one of %s", label, strings.Join(severities, ", "))
+	}
+	if svc.Critical && svc.Severity != "" && svc.Severity != SeverityCritical {
+		v.addf(at(item, "critical"), "service %q: critical: true conflicts with severity %q", label, svc.Severity)
+	}
+
+	if svc.Group != "" && !validLabel.MatchString(svc.Group) {
+		v.addf(at(item, "group"), "service %q: group %q may only contain letters, digits, '.', '_' and '-'", label, svc.Group)
+	}
+	for _, tag := range svc.Tags {
+		if !validLabel.MatchString(tag) {
+			v.addf(at(item, "tags"), "service %q: tag %q may only contain letters, digits, '.', '_' and '-'", label, tag)
+		}
+	}
+	if _, _, ok := parseSelector(svc.Name); ok {
+		v.addf(at(item, "name"), "service %q: name looks like a selector (@group, group: or tag:)", label)
+	}
+
+	if p := svc.Retry; p != nil {
+		retry := mappingValue(item, "retry")
+		if p.Delay < 0 {
+			v.addf(at(retry, "delay"), "service %q: retry.delay must not be negative", label)
+		}
+		if p.Multiplier != 0 && p.Multiplier < 1 {
+			v.addf(at(retry, "multiplier"), "service %q: retry.multiplier must be at least 1", label)
+		}
+		if p.MaxDelay < 0 {
+			v.addf(at(retry, "max_delay"), "service %q: retry.max_delay must not be negative", label)
+		}
+		if p.MaxDelay > 0 && p.Delay > p.MaxDelay {
+			v.addf(at(retry, "max_delay"), "service %q: retry.max_delay is smaller than retry.delay", label)
+		}
+		if p.Jitter < 0 || p.Jitter > 1 {
+			v.addf(at(retry, "jitter"), "service %q: retry.jitter must be between 0 and 1", label)
+		}
+		for _, code := range p.OnExitCodes {
+			if code < 0 || code > 255 {
+				v.addf(at(retry, "on_exit_codes"), "service %q: exit code %d is out of range 0-255", label, code)
+			}
+		}
+	}
+}
+
+// checkHooks validates hook settings; prefix names the service, if any
+func (v *configValidator) checkHooks(node *yaml.Node, hooks Hooks, prefix string) {
+	if hooks.HookDebounce < 0 {
+		v.addf(at(node, "hook_debounce"), "%shook_debounce must not be negative", prefix)
+	}
+	if hooks.HookTimeout < 0 {
+		v.addf(at(node, "hook_timeout"), "%shook_timeout must not be negative", prefix)
+	}
+}
+
+func (v *configValidator) checkNotifiers(seq *yaml.Node, notifiers []NotifierConfig) {
+	seen := make(map[string]bool)
+	for i, n := range notifiers {
+		var item *yaml.Node
+		if seq != nil && seq.Kind == yaml.SequenceNode && i < len(seq.Content) {
+			item = seq.Content[i]
+		}
+
+		label := n.Name
+		if strings.TrimSpace(n.Name) == "" {
+			v.addf(at(item, "name"), "notifier name must not be empty")
+			label = "<unnamed>"
+		} else if seen[n.Name] {
+			v.addf(at(item, "name"), "duplicate notifier name %q", n.Name)
+		}
+		seen[n.Name] = true
+
+		switch n.Type {
+		case NotifierWebhook, NotifierSlack:
+			if strings.TrimSpace(n.URL) == "" {
+				v.addf(at(item, "url"), "notifier %q: url must not be empty", label)
+			}
+			if n.SMTP != nil {
+				v.addf(at(item, "smtp"), "notifier %q: smtp settings only apply to type %q", label, NotifierSMTP)
+			}
+		case NotifierSMTP:
+			v.checkSMTP(mappingValue(item, "smtp"), item, n, label)
+		default:
+			v.addf(at(item, "type"), "notifier %q: type must be %q, %q or %q", label, NotifierWebhook, NotifierSlack, NotifierSMTP)
+		}
+		if n.Template != "" {
+			if _, err := parseNotifierTemplate(n.Template); err != nil {
+				v.addf(at(item, "template"), "notifier %q: invalid template: %v", label, err)
+			}
+		}
+		if n.MinSeverity != "" && !slices.Contains(severities, n.MinSeverity) {
+			v.addf(at(item, "min_severity"), "notifier %q: min_severity must be one of %s", label, strings.Join(severities, ", "))
+		}
+		for _, event := range n.Events {
+			if !slices.Contains(alertEvents, event) {
+				v.addf(at(item, "events"), "notifier %q: unknown event %q (expected %s)", label, event, strings.Join(alertEvents, ", "))
+			}
+		}
+		if n.Timeout < 0 {
+			v.addf(at(item, "timeout"), "notifier %q: timeout must not be negative", label)
+		}
+		if n.MaxAttempts < 0 {
+			v.addf(at(item, "max_attempts"), "notifier %q: max_attempts must not be negative", label)
+		}
+	}
+}
+
+func (v *configValidator) checkSMTP(node, item *yaml.Node, n NotifierConfig, label string) {
+	cfg := n.SMTP
+	if cfg == nil {
+		v.addf(at(item, "type"), "notifier %q: type %q needs an smtp section", label, NotifierSMTP)
+		return
+	}
+	if n.URL != "" {
+		v.addf(at(item, "url"), "notifier %q: url does not apply to type %q", label, NotifierSMTP)
+	}
+	if n.Template != "" {
+		v.addf(at(item, "template"), "notifier %q: use smtp.subject instead of template", label)
+	}
+	if strings.TrimSpace(cfg.Host) == "" {
+		v.addf(at(node, "host"), "notifier %q: smtp.host must not be empty", label)
+	}
+	if cfg.Port < 0 || cfg.Port > 65535 {
+		v.addf(at(node, "port"), "notifier %q: smtp.port is out of range", label)
+	}
+	if cfg.TLS != "" && cfg.TLS != SMTPStartTLS && cfg.TLS != SMTPTLS && cfg.TLS != SMTPNone {
+		v.addf(at(node, "tls"), "notifier %q: smtp.tls must be %q, %q or %q", label, SMTPStartTLS, SMTPTLS, SMTPNone)
+	}
+	if strings.TrimSpace(cfg.From) == "" {
+		v.addf(at(node, "from"), "notifier %q: smtp.from must not be empty", label)
+	}
+	if len(cfg.To) == 0 {
+		v.addf(at(node, "to"), "notifier %q: smtp.to must list at least one recipient", label)
+	}
+	if cfg.Subject != "" {
+		if _, err := parseSubjectTemplate(cfg.Subject); err != nil {
+			v.addf(at(node, "subject"), "notifier %q: invalid smtp.subject: %v", label, err)
+		}
+	}
+	if cfg.QuietPeriod < 0 {
+		v.addf(at(node, "quiet_period"), "notifier %q: smtp.quiet_period must not be negative", label)
+	}
+}
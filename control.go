@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Control socket protocol: newline-delimited JSON over a Unix socket.
+// Each connection sends one ControlRequest and reads ControlResponses.
+// Most commands answer once; "subscribe" keeps sending a response with
+// the new state after every check until the client disconnects.
+const (
+	ControlCheck     = "check"     // run checks now, optionally only for Services
+	ControlReload    = "reload"    // reload the config file
+	ControlStatus    = "status"    // return the live state
+	ControlSubscribe = "subscribe" // stream state after every check
+	ControlStop      = "stop"      // shut down; the connection closes once stopped
+)
+
+const controlTimeout = 5 * time.Second
+
+type ControlRequest struct {
+	Cmd      string   `json:"cmd"`
+	Services []string `json:"services,omitempty"`
+}
+
+type ControlResponse struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+	State *State `json:"state,omitempty"`
+}
+
+func getSocketPath() string {
+	home := getUserHomeDir()
+	return filepath.Join(home, ".cache", "gatekeeper", "daemon.sock")
+}
+
+// controlCall is a request handed to the daemon loop, which owns the config
+type controlCall struct {
+	req   ControlRequest
+	reply chan ControlResponse
+}
+
+// controlServer accepts connections on the control socket. Requests that
+// change daemon state are passed to the daemon loop through calls; status
+// and subscriptions are served from the last published state.
+type controlServer struct {
+	listener net.Listener
+	path     string
+	calls    chan controlCall
+	stopping chan struct{}
+	onStop   func() // called for ControlStop; must not block
+
+	mu          sync.Mutex
+	state       *State
+	subscribers map[chan *State]struct{}
+}
+
+func newControlServer(path string) (*controlServer, error) {
+	// Refuse to take over a socket another daemon is still serving
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("another daemon is listening on %s", path)
+	}
+	os.Remove(path)
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	os.Chmod(path, 0600)
+
+	return &controlServer{
+		listener:    listener,
+		path:        path,
+		calls:       make(chan controlCall),
+		stopping:    make(chan struct{}),
+		subscribers: make(map[chan *State]struct{}),
+	}, nil
+}
+
+// Serve accepts connections until Close is called
+func (s *controlServer) Serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			s.handle(conn)
+		}()
+	}
+}
+
+// Close stops accepting connections, ends subscriptions and removes the socket.
+// Connections waiting for the daemon to stop are closed last.
+func (s *controlServer) Close() {
+	s.listener.Close()
+
+	s.mu.Lock()
+	for ch := range s.subscribers {
+		close(ch)
+		delete(s.subscribers, ch)
+	}
+	s.mu.Unlock()
+
+	os.Remove(s.path)
+	close(s.stopping)
+}
+
+// Publish records the latest state and forwards it to subscribers
+func (s *controlServer) Publish(state *State) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.state = state
+	for ch := range s.subscribers {
+		// Drop a pending update the subscriber hasn't read yet;
+		// only the newest state matters
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- state
+	}
+}
+
+func (s *controlServer) currentState() *State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state
+}
+
+func (s *controlServer) handle(conn net.Conn) {
+	conn.SetReadDeadline(time.Now().Add(controlTimeout))
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil && len(line) == 0 {
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	enc := json.NewEncoder(conn)
+
+	var req ControlRequest
+	if err := json.Unmarshal(line, &req); err != nil {
+		enc.Encode(ControlResponse{Error: fmt.Sprintf("invalid request: %v", err)})
+		return
+	}
+
+	switch req.Cmd {
+	case ControlStatus:
+		enc.Encode(ControlResponse{OK: true, State: s.currentState()})
+
+	case ControlSubscribe:
+		s.subscribe(conn, enc)
+
+	case ControlStop:
+		// Handled here rather than in the daemon loop so a stop
+		// doesn't wait for a check that is in progress
+		s.onStop()
+		enc.Encode(ControlResponse{OK: true})
+
+		// Hold the connection until shutdown completes so the
+		// client knows when the daemon is really gone
+		<-s.stopping
+
+	case ControlCheck, ControlReload:
+		call := controlCall{req: req, reply: make(chan ControlResponse, 1)}
+		select {
+		case s.calls <- call:
+		case <-s.stopping:
+			enc.Encode(ControlResponse{Error: "daemon is shutting down"})
+			return
+		}
+		select {
+		case resp := <-call.reply:
+			enc.Encode(resp)
+		case <-s.stopping:
+			enc.Encode(ControlResponse{Error: "daemon is shutting down"})
+		}
+
+	default:
+		enc.Encode(ControlResponse{Error: fmt.Sprintf("unknown command %q", req.Cmd)})
+	}
+}
+
+func (s *controlServer) subscribe(conn net.Conn, enc *json.Encoder) {
+	ch := make(chan *State, 1)
+
+	s.mu.Lock()
+	s.subscribers[ch] = struct{}{}
+	initial := s.state
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		if _, ok := s.subscribers[ch]; ok {
+			delete(s.subscribers, ch)
+			close(ch)
+		}
+		s.mu.Unlock()
+	}()
+
+	if err := enc.Encode(ControlResponse{OK: true, State: initial}); err != nil {
+		return
+	}
+
+	// Detect the client going away while we wait for updates
+	gone := make(chan struct{})
+	go func() {
+		buf := make([]byte, 1)
+		for {
+			if _, err := conn.Read(buf); err != nil {
+				close(gone)
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case state, ok := <-ch:
+			if !ok {
+				return
+			}
+			if err := enc.Encode(ControlResponse{OK: true, State: state}); err != nil {
+				return
+			}
+		case <-gone:
+			return
+		}
+	}
+}
+
+// dialControl connects to the daemon's control socket
+func dialControl() (net.Conn, error) {
+	return net.DialTimeout("unix", getSocketPath(), time.Second)
+}
+
+// sendControl sends a single request and returns the first response.
+// timeout bounds the whole exchange, including how long the daemon
+// takes to act (a check can take as long as the slowest service).
+func sendControl(req ControlRequest, timeout time.Duration) (*ControlResponse, error) {
+	conn, err := dialControl()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(timeout))
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, err
+	}
+
+	var resp ControlResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, err
+	}
+	if !resp.OK {
+		return &resp, fmt.Errorf("%s", resp.Error)
+	}
+	return &resp, nil
+}
+
+// requestStop asks the daemon to shut down and waits until it has,
+// signaled by the daemon closing the connection
+func requestStop(timeout time.Duration) error {
+	conn, err := dialControl()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(timeout))
+	if err := json.NewEncoder(conn).Encode(ControlRequest{Cmd: ControlStop}); err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(conn)
+	var resp ControlResponse
+	if err := dec.Decode(&resp); err != nil {
+		return err
+	}
+	if !resp.OK {
+		return fmt.Errorf("%s", resp.Error)
+	}
+
+	// Nothing else is sent; wait for EOF
+	if err := dec.Decode(&resp); err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
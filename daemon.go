@@ -6,6 +6,8 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"slices"
+	"strings"
 	"syscall"
 	"time"
 )
@@ -13,17 +15,54 @@ import (
 var daemonLogger *Logger
 var daemonStartTime time.Time
 
-func runDaemon(config *Config) {
+func getPIDPath() string {
+	home := getUserHomeDir()
+	return filepath.Join(home, ".cache", "gatekeeper", "daemon.pid")
+}
+
+// readDaemonPID returns the PID stored in the PID file
+func readDaemonPID() (int, error) {
+	pidBytes, err := os.ReadFile(getPIDPath())
+	if err != nil {
+		return 0, err
+	}
+
+	var pid int
+	if _, err := fmt.Sscanf(string(pidBytes), "%d", &pid); err != nil || pid <= 0 {
+		return 0, fmt.Errorf("invalid PID file")
+	}
+	return pid, nil
+}
+
+func runDaemon(configFile string, config *Config, lenient, textfile bool) {
 	daemonLogger = NewLogger(LogInfo)
 	defer daemonLogger.Close()
 
 	daemonStartTime = time.Now()
 
+	// Handle signals for graceful shutdown; cancelling the context
+	// also interrupts checks and retry backoff that are in flight
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Control socket for clients; the daemon still works without it
+	var calls chan controlCall
+	server, err := newControlServer(getSocketPath())
+	if err != nil {
+		daemonLogger.Warnf("Control socket disabled: %v", err)
+	} else {
+		server.onStop = func() {
+			daemonLogger.Info("Stop requested via control socket, shutting down gracefully")
+			cancel()
+		}
+		calls = server.calls
+		go server.Serve()
+		defer server.Close()
+	}
+
 	// Save PID file
-	home := getUserHomeDir()
-	cacheDir := filepath.Join(home, ".cache/gatekeeper")
-	os.MkdirAll(cacheDir, 0755)
-	pidFile := filepath.Join(cacheDir, "daemon.pid")
+	pidFile := getPIDPath()
+	os.MkdirAll(filepath.Dir(pidFile), 0755)
 	pidBytes := []byte(fmt.Sprintf("%d", os.Getpid()))
 	if err := os.WriteFile(pidFile, pidBytes, 0644); err != nil {
 		daemonLogger.Warnf("Error saving PID file: %v", err)
@@ -36,35 +75,279 @@ func runDaemon(config *Config) {
 	}()
 
 	daemonLogger.Info("Gatekeeper daemon starting...")
+	daemonLogger.Infof("Config file: %s", configFile)
 	daemonLogger.Infof("Checking interval: %d seconds", config.Interval)
 	daemonLogger.Infof("Found %d services to monitor", len(config.Services))
+	for _, svc := range config.Services {
+		if svc.Interval > 0 {
+			daemonLogger.Infof("[%s] checking every %d seconds", svc.Name, svc.Interval)
+		}
+	}
 
-	ticker := time.NewTicker(time.Duration(config.Interval) * time.Second)
-	defer ticker.Stop()
+	// Each service runs on its own interval; the timer wakes the
+	// loop when the next one is due
+	sched := newScheduler()
+	timer := time.NewTimer(0)
+	defer timer.Stop()
 
-	// Handle signals for graceful shutdown
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-sigChan
+		daemonLogger.Infof("Received signal %v, shutting down gracefully", sig)
+		cancel()
+	}()
+
+	// SIGHUP reloads the config
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
+
+	// Optional polling for config file changes
+	watcher := newConfigWatcher(configFile)
+	watchTicker := time.NewTicker(configPollInterval)
+	defer watchTicker.Stop()
+	if config.WatchConfig {
+		daemonLogger.Info("Watching config file for changes")
+	}
+
+	// Record state changes for 'gatekeeper history'
+	history := newHistoryStore(getHistoryPath(), config.History)
+
+	// State changes are compared against the state the previous daemon
+	// left behind and drive hooks and notifications
+	previous, err := readStateFile()
+	if err != nil {
+		daemonLogger.Warnf("Could not read previous state: %v", err)
+	}
+	tracker := newTransitionTracker(previous)
+	hooks := newHookRunner(daemonLogger)
+	detector := newAlertDetector()
+	notifier := newNotificationManager(ctx, newDBusNotifier(), daemonLogger)
+
+	// Alerts for webhooks wait in the outbox until delivered
+	outbox := newAlertOutbox(getOutboxDir(), daemonLogger)
+	outbox.Configure(config)
+	go outbox.Run(ctx)
+
+	// Prometheus metrics, served over HTTP and/or written for node_exporter
+	metrics := newMetricsCollector(daemonStartTime)
+	metrics.Configure(config)
+	metricsServer := newMetricsServer(metrics, daemonLogger)
+	metricsServer.Configure(config.Metrics.Listen)
+	defer metricsServer.Close()
+
+	// Optional HTTP API for dashboards and editor plugins
+	api := newAPIServer(daemonLogger)
+	api.Configure(config.API)
+	defer api.Close()
+
+	// Last published state, used to keep results of services
+	// that were not part of a partial check
+	var state *State
 
-	// Run once immediately
-	checkAndUpdateState(config)
+	// update checks the named services, or all if only is nil
+	update := func(only []string) {
+		sched.Started(config, only, time.Now())
+		newState, fresh := checkAndUpdateState(ctx, config, state, only, sched)
+		if newState == nil {
+			return
+		}
+		state = newState
+		server.Publish(state)
+
+		if err := history.Record(fresh); err != nil {
+			daemonLogger.Errorf("Error recording history: %v", err)
+		}
+
+		transitions := tracker.ObserveAll(config, fresh)
+		for _, tr := range transitions {
+			daemonLogger.Infof("[%s] state changed: %s -> %s", tr.Service, tr.From, tr.To)
+		}
+		hooks.Run(config, transitions)
+		api.Publish(state, transitions)
+
+		alerts := detector.Detect(config, transitions, fresh)
+		notifier.Update(config, alerts)
+		outbox.Enqueue(alerts)
+
+		metrics.Observe(fresh)
+		if textfile || config.Metrics.Textfile {
+			if err := metrics.WriteTextfile(getTextfilePath()); err != nil {
+				daemonLogger.Errorf("Error writing metrics textfile: %v", err)
+			}
+		}
+	}
+
+	reload := func(reason string) error {
+		newConfig, err := reloadConfig(configFile, lenient, config, reason)
+		if err != nil {
+			return err
+		}
+		diff := diffConfigs(config, newConfig)
+		config = newConfig
+		history.Configure(config.History)
+		outbox.Configure(config)
+		metrics.Configure(config)
+		metricsServer.Configure(config.Metrics.Listen)
+		api.Configure(config.API)
+
+		// Added and changed services are checked right away; the rest
+		// keep their schedule under the (possibly new) intervals.
+		// An empty non-nil list still rewrites state to drop removed services.
+		sched.Sync(config)
+		recheck := append([]string{}, diff.Added...)
+		recheck = append(recheck, diff.Changed...)
+		sched.Forget(recheck)
+		update(recheck)
+		return nil
+	}
 
-	// Then run on interval
+	// serve answers a request from the control socket or the API
+	serve := func(call controlCall, source string) {
+		resp := handleControlCall(call.req, source, config, update, reload)
+		if resp.OK {
+			resp.State = state
+		}
+		call.reply <- resp
+		if call.req.Cmd == ControlReload {
+			watcher.Changed()
+		}
+	}
+
+	// Run on each service's own interval, starting with all of them now
 	for {
+		if next, ok := sched.NextWake(config); ok {
+			timer.Reset(time.Until(next))
+		} else {
+			timer.Reset(time.Duration(config.Interval) * time.Second)
+		}
+
 		select {
-		case <-ticker.C:
-			checkAndUpdateState(config)
-		case sig := <-sigChan:
-			daemonLogger.Infof("Received signal %v, shutting down gracefully", sig)
+		case <-timer.C:
+			if due := sched.Due(config, time.Now()); len(due) > 0 {
+				if len(due) == len(config.Services) {
+					due = nil
+				}
+				update(due)
+			}
+		case <-hupChan:
+			reload("SIGHUP")
+			// Keep the watcher from firing again for the same edit
+			watcher.Changed()
+		case <-watchTicker.C:
+			if watcher.Changed() && config.WatchConfig {
+				reload("file change")
+			}
+		case call := <-calls:
+			serve(call, "control socket")
+		case call := <-api.calls:
+			serve(call, "API")
+		case <-ctx.Done():
 			return
 		}
 	}
 }
 
-func checkAndUpdateState(config *Config) {
-	ctx := context.Background()
+// handleControlCall runs a request from the control socket or the API,
+// named by source in the log, inside the daemon loop. The caller attaches
+// the resulting state to successful responses.
+func handleControlCall(req ControlRequest, source string, config *Config, update func([]string), reload func(string) error) ControlResponse {
+	switch req.Cmd {
+	case ControlCheck:
+		names, err := resolveServiceNames(config, req.Services)
+		if err != nil {
+			return ControlResponse{Error: err.Error()}
+		}
+		daemonLogger.Infof("Check requested via %s (%s)", source, describeServices(names))
+		update(names)
+
+	case ControlReload:
+		if err := reload(source); err != nil {
+			return ControlResponse{Error: err.Error()}
+		}
 
-	state := &State{}
+	default:
+		return ControlResponse{Error: fmt.Sprintf("unknown command %q", req.Cmd)}
+	}
+
+	return ControlResponse{OK: true}
+}
+
+// resolveServiceNames maps requested names to configured ones (case-insensitive).
+// Requests may also be selectors such as @work or tag:aws.
+// An empty request means all services and returns nil.
+func resolveServiceNames(config *Config, requested []string) ([]string, error) {
+	if len(requested) == 0 {
+		return nil, nil
+	}
+
+	var names, unknown []string
+	for _, name := range requested {
+		selected := selectServices(config, name)
+		if len(selected) == 0 {
+			unknown = append(unknown, name)
+		}
+		for _, svc := range selected {
+			if !slices.Contains(names, svc.Name) {
+				names = append(names, svc.Name)
+			}
+		}
+	}
+	if len(unknown) > 0 {
+		return nil, fmt.Errorf("unknown service(s): %s", strings.Join(unknown, ", "))
+	}
+	return names, nil
+}
+
+func describeServices(names []string) string {
+	if len(names) == 0 {
+		return "all services"
+	}
+	return strings.Join(names, ", ")
+}
+
+// reloadConfig re-reads and validates the config file.
+// On failure the error is logged and the current config stays active.
+func reloadConfig(configFile string, lenient bool, current *Config, reason string) (*Config, error) {
+	daemonLogger.Infof("Reloading config (%s)", reason)
+
+	newConfig, warning, err := loadDaemonConfig(configFile, lenient)
+	if err != nil {
+		daemonLogger.Errorf("Config reload failed, keeping previous config: %v", err)
+		return nil, err
+	}
+	if warning != nil {
+		daemonLogger.Warnf("Config has problems, loaded leniently: %v", warning)
+	}
+
+	diff := diffConfigs(current, newConfig)
+	if diff.Empty() {
+		daemonLogger.Info("Config reloaded, no changes")
+		return newConfig, nil
+	}
+
+	if len(diff.Added) > 0 {
+		daemonLogger.Infof("Services added: %s", strings.Join(diff.Added, ", "))
+	}
+	if len(diff.Removed) > 0 {
+		daemonLogger.Infof("Services removed: %s", strings.Join(diff.Removed, ", "))
+	}
+	if len(diff.Changed) > 0 {
+		daemonLogger.Infof("Services changed: %s", strings.Join(diff.Changed, ", "))
+	}
+	if diff.Interval {
+		daemonLogger.Infof("Checking interval: %d -> %d seconds", current.Interval, newConfig.Interval)
+	}
+	daemonLogger.Infof("Config reloaded, %d services to monitor", len(newConfig.Services))
+	return newConfig, nil
+}
+
+// checkAndUpdateState checks services and saves the resulting state.
+// When only is non-nil just those services are checked and the rest
+// keep their results from previous. Returns the new state and the fresh
+// results, or nil if the run was interrupted.
+func checkAndUpdateState(ctx context.Context, config *Config, previous *State, only []string, sched *scheduler) (*State, []ServiceStatus) {
+	state := &State{SchemaVersion: StateSchemaVersion}
 
 	// Add daemon status
 	state.Daemon = &DaemonStatus{
@@ -74,18 +357,102 @@ func checkAndUpdateState(config *Config) {
 		LastCheck: time.Now(),
 	}
 
-	// Create checker with enhanced features
+	// Create checker with enhanced features; per-service timeout,
+	// retries and retry_delay from config override these defaults
 	checker := NewEnhancedChecker(CheckerOptions{
-		Logger:  daemonLogger,
-		Retries: 1,
+		ExpiryWarning: time.Duration(config.ExpiryWarning) * time.Second,
+		Logger:        daemonLogger,
 	})
 
+	// Services are checked together with those they depend on
+	services := config.Services
+	if only != nil {
+		services = withDependencies(config.Services, filterServices(config.Services, only))
+	}
+
 	// Check all services concurrently
-	statuses := checker.CheckBatch(ctx, config.Services)
+	statuses := checker.CheckBatch(ctx, services)
+	fresh := statuses
 
+	if only != nil {
+		statuses = mergeStatuses(config.Services, statuses, previous)
+	}
+
+	// Tell readers when each result will be refreshed
+	for i := range statuses {
+		if svc, ok := findService(config, statuses[i].Name); ok {
+			statuses[i].NextCheck = sched.Next(config, svc)
+		}
+	}
 	state.Services = statuses
 
-	if err := saveState(state); err != nil {
+	// Results from an interrupted run are incomplete, keep the previous state
+	if ctx.Err() != nil {
+		return nil, nil
+	}
+
+	if err := saveDaemonState(state); err != nil {
 		daemonLogger.Errorf("Error saving state: %v", err)
 	}
+	return state, fresh
+}
+
+// saveDaemonState writes the daemon's state to state.json. A result that
+// a CLI command such as 'check --update-state' or 'auth' saved after the
+// daemon last checked that service is newer, so it is kept and taken over
+// into state until the daemon checks the service again.
+func saveDaemonState(state *State) error {
+	unlock, err := lockState()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// A file that can't be read has nothing worth keeping
+	if onDisk, err := readStateFile(); err == nil {
+		saved := make(map[string]ServiceStatus, len(onDisk.Services))
+		for _, st := range onDisk.Services {
+			saved[st.Name] = st
+		}
+		for i, st := range state.Services {
+			if newer, ok := saved[st.Name]; ok && newer.LastChecked.After(st.LastChecked) {
+				newer.NextCheck = st.NextCheck
+				state.Services[i] = newer
+			}
+		}
+	}
+	return writeStateFile(state)
+}
+
+// filterServices returns the services whose names are listed, in config order
+func filterServices(services []Service, names []string) []Service {
+	var filtered []Service
+	for _, svc := range services {
+		if slices.Contains(names, svc.Name) {
+			filtered = append(filtered, svc)
+		}
+	}
+	return filtered
+}
+
+// mergeStatuses combines fresh results with the previous state, in config order.
+// Services with neither a fresh nor a previous result are left out.
+func mergeStatuses(services []Service, fresh []ServiceStatus, previous *State) []ServiceStatus {
+	byName := make(map[string]ServiceStatus)
+	if previous != nil {
+		for _, st := range previous.Services {
+			byName[st.Name] = st
+		}
+	}
+	for _, st := range fresh {
+		byName[st.Name] = st
+	}
+
+	var merged []ServiceStatus
+	for _, svc := range services {
+		if st, ok := byName[svc.Name]; ok {
+			merged = append(merged, st)
+		}
+	}
+	return merged
 }
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// dependencyCycleError names services that depend on each other in loops.
+// Unplaced also has the services that depend on a loop, directly or not.
+type dependencyCycleError struct {
+	Cycles   [][]string // first service repeated at the end, e.g. [A B A]
+	Unplaced []string   // every service that can't be ordered
+}
+
+func (e *dependencyCycleError) Error() string {
+	cycles := make([]string, len(e.Cycles))
+	for i, path := range e.Cycles {
+		cycles[i] = strings.Join(path, " -> ")
+	}
+	if len(cycles) == 1 {
+		return "dependency cycle: " + cycles[0]
+	}
+	return "dependency cycles: " + strings.Join(cycles, ", ")
+}
+
+// indexByName maps lowercased service names to their position
+func indexByName(services []Service) map[string]int {
+	index := make(map[string]int, len(services))
+	for i, svc := range services {
+		index[strings.ToLower(svc.Name)] = i
+	}
+	return index
+}
+
+// dependencyOrder sorts services so that each comes after those it depends
+// on, keeping config order where dependencies allow. Dependencies on
+// services outside the list are ignored.
+func dependencyOrder(services []Service) ([]Service, error) {
+	index := indexByName(services)
+	placed := make([]bool, len(services))
+
+	var ordered []Service
+	for len(ordered) < len(services) {
+		progress := false
+		for i, svc := range services {
+			if placed[i] {
+				continue
+			}
+			ready := true
+			for _, dep := range svc.DependsOn {
+				if j, ok := index[strings.ToLower(dep)]; ok && !placed[j] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				placed[i] = true
+				ordered = append(ordered, svc)
+				progress = true
+				// Start over so earlier services that were waiting on
+				// this one keep their place
+				break
+			}
+		}
+		if !progress {
+			return nil, findDependencyCycles(services, placed)
+		}
+	}
+	return ordered, nil
+}
+
+// findDependencyCycles returns the cycles among the services not yet placed
+func findDependencyCycles(services []Service, placed []bool) error {
+	index := indexByName(services)
+	cycleErr := &dependencyCycleError{}
+	for i, svc := range services {
+		if !placed[i] {
+			cycleErr.Unplaced = append(cycleErr.Unplaced, svc.Name)
+		}
+	}
+
+	// explained marks cycle members and the services that depend on them
+	explained := slices.Clone(placed)
+
+	// Every unexplained service waits on another unexplained one, so
+	// following those edges must come back around
+	for i := slices.Index(explained, false); i >= 0; i = slices.Index(explained, false) {
+		var path []int
+		for !slices.Contains(path, i) {
+			path = append(path, i)
+			for _, dep := range services[i].DependsOn {
+				if j, ok := index[strings.ToLower(dep)]; ok && !explained[j] {
+					i = j
+					break
+				}
+			}
+		}
+
+		var cycle []string
+		for _, j := range path[slices.Index(path, i):] {
+			cycle = append(cycle, services[j].Name)
+			explained[j] = true
+		}
+		cycle = append(cycle, services[i].Name)
+		cycleErr.Cycles = append(cycleErr.Cycles, cycle)
+
+		// Services that depend on this cycle are stuck because of it
+		for changed := true; changed; {
+			changed = false
+			for k, svc := range services {
+				if explained[k] {
+					continue
+				}
+				for _, dep := range svc.DependsOn {
+					if j, ok := index[strings.ToLower(dep)]; ok && !placed[j] && explained[j] {
+						explained[k] = true
+						changed = true
+						break
+					}
+				}
+			}
+		}
+	}
+	return cycleErr
+}
+
+// withDependencies returns services plus everything they depend on,
+// directly or not, in config order
+func withDependencies(all []Service, services []Service) []Service {
+	index := indexByName(all)
+	needed := make([]bool, len(all))
+
+	var visit func(svc Service)
+	visit = func(svc Service) {
+		i, ok := index[strings.ToLower(svc.Name)]
+		if !ok || needed[i] {
+			return
+		}
+		needed[i] = true
+		for _, dep := range svc.DependsOn {
+			if j, ok := index[strings.ToLower(dep)]; ok {
+				visit(all[j])
+			}
+		}
+	}
+	for _, svc := range services {
+		visit(svc)
+	}
+
+	var result []Service
+	for i, svc := range all {
+		if needed[i] {
+			result = append(result, svc)
+		}
+	}
+	return result
+}
+
+// blockedStatus is the result for a service that wasn't checked because
+// the dependency dep isn't authenticated
+func blockedStatus(service Service, dep ServiceStatus) ServiceStatus {
+	reason := fmt.Sprintf("%s is %s", dep.Name, statusState(dep))
+	if statusState(dep) == StateOK {
+		reason = fmt.Sprintf("%s has expired", dep.Name)
+	}
+	return ServiceStatus{
+		Name:        service.Name,
+		Icon:        getServiceIcon(service.Name, service.Icon),
+		Group:       service.Group,
+		Tags:        service.Tags,
+		State:       StateBlocked,
+		Error:       reason,
+		LastChecked: time.Now(),
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// twoCycles has the loops A <-> B and C <-> D, E behind the first one
+// and F depending on nothing
+var twoCycles = []Service{
+	{Name: "A", CheckCmd: "true", DependsOn: []string{"B"}},
+	{Name: "B", CheckCmd: "true", DependsOn: []string{"A"}},
+	{Name: "C", CheckCmd: "true", DependsOn: []string{"D"}},
+	{Name: "D", CheckCmd: "true", DependsOn: []string{"C"}},
+	{Name: "E", CheckCmd: "true", DependsOn: []string{"a"}},
+	{Name: "F", CheckCmd: "true"},
+}
+
+func TestDependencyOrder(t *testing.T) {
+	services := []Service{
+		{Name: "Vault", DependsOn: []string{"VPN"}},
+		{Name: "aws-prod", DependsOn: []string{"okta"}},
+		{Name: "VPN"},
+		{Name: "Okta"},
+		{Name: "GitHub", DependsOn: []string{"not in the list"}},
+	}
+	ordered, err := dependencyOrder(services)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, svc := range ordered {
+		names = append(names, svc.Name)
+	}
+	if got, want := strings.Join(names, " "), "VPN Vault Okta aws-prod GitHub"; got != want {
+		t.Errorf("got order %s, want %s", got, want)
+	}
+}
+
+func TestDependencyOrderReportsEveryCycle(t *testing.T) {
+	_, err := dependencyOrder(twoCycles)
+	var cycleErr *dependencyCycleError
+	if !errors.As(err, &cycleErr) {
+		t.Fatalf("got %v, want a dependencyCycleError", err)
+	}
+
+	if got, want := err.Error(), "dependency cycles: A -> B -> A, C -> D -> C"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := strings.Join(cycleErr.Unplaced, " "), "A B C D E"; got != want {
+		t.Errorf("got unplaced %s, want %s", got, want)
+	}
+}
+
+func TestCheckBatchWithCycles(t *testing.T) {
+	checker := NewEnhancedChecker(CheckerOptions{})
+	results := make(chan []ServiceStatus, 1)
+	go func() { results <- checker.CheckBatch(context.Background(), twoCycles) }()
+
+	var statuses []ServiceStatus
+	select {
+	case statuses = <-results:
+	case <-time.After(10 * time.Second):
+		t.Fatal("CheckBatch hangs on dependency cycles")
+	}
+
+	for _, st := range statuses {
+		want := StateError
+		if st.Name == "F" {
+			want = StateOK
+		}
+		if st.State != want {
+			t.Errorf("%s: got %s (%s), want %s", st.Name, st.State, st.Error, want)
+		}
+	}
+}
+
+func TestCheckBatchBlocksDependents(t *testing.T) {
+	services := []Service{
+		{Name: "Vault", CheckCmd: "true", DependsOn: []string{"VPN"}},
+		{Name: "VPN", CheckCmd: "false"},
+		{Name: "Okta", CheckCmd: "true"},
+		{Name: "aws-prod", CheckCmd: "true", DependsOn: []string{"Okta"}},
+	}
+	statuses := NewEnhancedChecker(CheckerOptions{}).CheckBatch(context.Background(), services)
+
+	want := map[string]CheckState{
+		"Vault":    StateBlocked,
+		"VPN":      StateUnauthenticated,
+		"Okta":     StateOK,
+		"aws-prod": StateOK,
+	}
+	for _, st := range statuses {
+		if st.State != want[st.Name] {
+			t.Errorf("%s: got %s, want %s", st.Name, st.State, want[st.Name])
+		}
+	}
+	if statuses[0].Error != "VPN is unauthenticated" {
+		t.Errorf("got blocked reason %q", statuses[0].Error)
+	}
+}
+
+func TestUnreadyServicesIgnoresDisabledDependencies(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	config := &Config{Services: []Service{
+		{Name: "VPN", CheckCmd: "false", Disabled: true},
+		{Name: "Vault", CheckCmd: "true", DependsOn: []string{"VPN"}},
+	}}
+
+	// As in 'run --require Vault', which pulls in the disabled VPN
+	services := withDependencies(config.Services, config.Services[1:])
+	if failing := unreadyServices(config, services, 0); len(failing) != 0 {
+		t.Errorf("got unready %+v, want none", failing)
+	}
+
+	config.Services[1].CheckCmd = "false"
+	failing := unreadyServices(config, services, 0)
+	if len(failing) != 1 || failing[0].Name != "Vault" {
+		t.Errorf("got unready %+v, want Vault", failing)
+	}
+}
+
+func TestWithDependencies(t *testing.T) {
+	all := []Service{
+		{Name: "Vault", DependsOn: []string{"VPN"}},
+		{Name: "VPN", DependsOn: []string{"Wifi"}},
+		{Name: "Wifi"},
+		{Name: "GitHub"},
+	}
+	var names []string
+	for _, svc := range withDependencies(all, all[:1]) {
+		names = append(names, svc.Name)
+	}
+	if want := []string{"Vault", "VPN", "Wifi"}; !slices.Equal(names, want) {
+		t.Errorf("got %v, want %v", names, want)
+	}
+}
+
+func TestValidateReportsEveryCycle(t *testing.T) {
+	data := []byte(`services:
+  - name: A
+    check_cmd: "true"
+    depends_on: [B]
+  - name: B
+    check_cmd: "true"
+    depends_on: [A]
+  - name: C
+    check_cmd: "true"
+    depends_on: [D]
+  - name: D
+    check_cmd: "true"
+    depends_on: [C]
+  - name: E
+    check_cmd: "true"
+    depends_on: [E]
+`)
+	_, issues, err := validateConfig("config.yaml", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var messages []string
+	for _, issue := range issues {
+		messages = append(messages, issue.String())
+	}
+	for _, want := range []string{
+		"config.yaml:4:17: dependency cycle: A -> B -> A",
+		"config.yaml:10:17: dependency cycle: C -> D -> C",
+		`service "E": depends_on lists the service itself`,
+	} {
+		if !slices.ContainsFunc(messages, func(m string) bool { return strings.Contains(m, want) }) {
+			t.Errorf("missing issue %q in:\n%s", want, strings.Join(messages, "\n"))
+		}
+	}
+	if len(messages) != 3 {
+		t.Errorf("got %d issues, want 3:\n%s", len(messages), strings.Join(messages, "\n"))
+	}
+}
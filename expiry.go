@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultExpiryWarning is how long before expiry a session is flagged
+const DefaultExpiryWarning = 15 * time.Minute
+
+// expiryLayouts are tried in order when parsing an expiry timestamp
+var expiryLayouts = []string{
+	time.RFC3339Nano,
+	time.RFC3339,
+	"2006-01-02T15:04:05UTC", // AWS SSO cache
+	"2006-01-02T15:04:05Z0700",
+	"2006-01-02 15:04:05 -0700 MST",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05",
+	time.RFC1123Z,
+	time.RFC1123,
+	time.UnixDate,
+}
+
+// hasExpiry reports whether the service is configured to track expiry
+func (s Service) hasExpiry() bool {
+	return s.ExpiryCmd != "" || s.ExpiryRegex != "" || s.ExpiryJSONPath != ""
+}
+
+// extractExpiry pulls an expiry time out of command output using the
+// service's regex or JSON path; without either the whole output is parsed
+func extractExpiry(service Service, output []byte, now time.Time) (time.Time, error) {
+	value := strings.TrimSpace(string(output))
+
+	switch {
+	case service.ExpiryRegex != "":
+		re, err := regexp.Compile(service.ExpiryRegex)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid expiry_regex: %w", err)
+		}
+		m := re.FindStringSubmatch(value)
+		if m == nil {
+			return time.Time{}, fmt.Errorf("expiry_regex did not match")
+		}
+		// Use the first capture group if there is one
+		value = m[0]
+		if len(m) > 1 {
+			value = m[1]
+		}
+
+	case service.ExpiryJSONPath != "":
+		var doc interface{}
+		if err := json.Unmarshal(output, &doc); err != nil {
+			return time.Time{}, fmt.Errorf("output is not JSON: %w", err)
+		}
+		v, err := lookupJSONPath(doc, service.ExpiryJSONPath)
+		if err != nil {
+			return time.Time{}, err
+		}
+		value = fmt.Sprint(v)
+		if f, ok := v.(float64); ok {
+			value = strconv.FormatFloat(f, 'f', -1, 64)
+		}
+	}
+
+	return parseExpiry(value, now)
+}
+
+// lookupJSONPath resolves a dotted path like "Credentials.Expiration"
+// or "tokens[0].expires_at" in a decoded JSON document
+func lookupJSONPath(doc interface{}, path string) (interface{}, error) {
+	cur := doc
+	for _, part := range strings.Split(strings.TrimPrefix(path, "."), ".") {
+		name := part
+		var indexes []int
+		if i := strings.Index(part, "["); i >= 0 {
+			name = part[:i]
+			for _, idx := range strings.Split(strings.Trim(part[i:], "[]"), "][") {
+				n, err := strconv.Atoi(idx)
+				if err != nil {
+					return nil, fmt.Errorf("invalid index in expiry_json_path %q", path)
+				}
+				indexes = append(indexes, n)
+			}
+		}
+
+		if name != "" {
+			obj, ok := cur.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("expiry_json_path %q: %q is not an object", path, name)
+			}
+			if cur, ok = obj[name]; !ok {
+				return nil, fmt.Errorf("expiry_json_path %q: key %q not found", path, name)
+			}
+		}
+
+		for _, n := range indexes {
+			arr, ok := cur.([]interface{})
+			if !ok || n < 0 || n >= len(arr) {
+				return nil, fmt.Errorf("expiry_json_path %q: index %d out of range", path, n)
+			}
+			cur = arr[n]
+		}
+	}
+	return cur, nil
+}
+
+// minUnixExpiry is the smallest bare number taken as a Unix time (2001-09-09).
+// Smaller ones are TTLs in seconds, such as Vault's ttl.
+const minUnixExpiry = 1e9
+
+// parseExpiry accepts a timestamp in a common layout, a Unix time in
+// seconds or milliseconds, a number of seconds from now (e.g. "3600"),
+// or a Go duration relative to now (e.g. "45m")
+func parseExpiry(value string, now time.Time) (time.Time, error) {
+	value = strings.Trim(strings.TrimSpace(value), `"'`)
+	if value == "" {
+		return time.Time{}, fmt.Errorf("empty expiry value")
+	}
+
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+		switch {
+		case n > 1e12:
+			return time.UnixMilli(n), nil
+		case n >= minUnixExpiry:
+			return time.Unix(n, 0), nil
+		}
+		return now.Add(time.Duration(n) * time.Second), nil
+	}
+
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(d), nil
+	}
+
+	for _, layout := range expiryLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized expiry time %q", value)
+}
+
+// expiryWarning returns the warning window for a status
+func expiryWarning(s ServiceStatus) time.Duration {
+	if s.ExpiryWarning > 0 {
+		return time.Duration(s.ExpiryWarning) * time.Second
+	}
+	return DefaultExpiryWarning
+}
+
+// expiresIn returns the time left before expiry and whether the status
+// has an expiry at all
+func expiresIn(s ServiceStatus) (time.Duration, bool) {
+	if s.ExpiresAt == nil {
+		return 0, false
+	}
+	return time.Until(*s.ExpiresAt), true
+}
+
+// isExpiringSoon reports whether an alive session ends within its warning window
+func isExpiringSoon(s ServiceStatus) bool {
+	left, ok := expiresIn(s)
+	return ok && s.IsAlive && left > 0 && left <= expiryWarning(s)
+}
+
+// isExpired reports whether the recorded expiry has already passed
+func isExpired(s ServiceStatus) bool {
+	left, ok := expiresIn(s)
+	return ok && left <= 0
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseExpiry(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	want := time.Date(2026, 3, 1, 13, 30, 0, 0, time.UTC)
+
+	for _, value := range []string{
+		"2026-03-01T13:30:00Z",
+		"2026-03-01T13:30:00.000Z",
+		"2026-03-01T14:30:00+01:00",
+		`"2026-03-01T13:30:00Z"`,
+		"2026-03-01T13:30:00UTC",
+		"2026-03-01T13:30:00+0000",
+		"2026-03-01 13:30:00 +0000 UTC",
+		"2026-03-01 13:30:00Z",
+		"2026-03-01 13:30:00",
+		"Sun, 01 Mar 2026 13:30:00 +0000",
+		"Sun, 01 Mar 2026 13:30:00 UTC",
+		"Sun Mar  1 13:30:00 UTC 2026",
+		"1772371800",    // Unix seconds
+		"1772371800000", // Unix milliseconds
+		"5400",          // TTL in seconds
+		"1h30m",
+		"  90m\n",
+	} {
+		got, err := parseExpiry(value, now)
+		if err != nil {
+			t.Errorf("parseExpiry(%q): %v", value, err)
+			continue
+		}
+		if !got.Equal(want) {
+			t.Errorf("parseExpiry(%q) = %s, want %s", value, got, want)
+		}
+	}
+
+	for _, value := range []string{"", `""`, "soon", "2026-13-01"} {
+		if got, err := parseExpiry(value, now); err == nil {
+			t.Errorf("parseExpiry(%q) = %s, want an error", value, got)
+		}
+	}
+}
+
+func TestExtractExpiry(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		svc    Service
+		output string
+		want   time.Time
+	}{
+		{Service{ExpiryRegex: `ttl\s+(\S+)`}, "key  value\nttl  3600\n", now.Add(time.Hour)},
+		{Service{ExpiryJSONPath: "data.ttl"}, `{"data": {"ttl": 3600}}`, now.Add(time.Hour)},
+		{Service{ExpiryJSONPath: "Credentials.Expiration"}, `{"Credentials": {"Expiration": "2026-03-01T13:00:00Z"}}`, now.Add(time.Hour)},
+		{Service{ExpiryJSONPath: "tokens[1].expires_at"}, `{"tokens": [{}, {"expires_at": 1772370000}]}`, now.Add(time.Hour)},
+	} {
+		got, err := extractExpiry(tc.svc, []byte(tc.output), now)
+		if err != nil {
+			t.Errorf("%+v: %v", tc.svc, err)
+			continue
+		}
+		if !got.Equal(tc.want) {
+			t.Errorf("%+v: got %s, want %s", tc.svc, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Selectors pick services by group or tag wherever names are accepted
+const (
+	SelectorGroup = "group" // @work or group:work
+	SelectorTag   = "tag"   // tag:aws
+)
+
+// validLabel matches group and tag names
+var validLabel = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// parseSelector splits "@work", "group:work" or "tag:aws" into its kind
+// and value. Anything else is a plain service name.
+func parseSelector(s string) (kind, value string, ok bool) {
+	if group, ok := strings.CutPrefix(s, "@"); ok {
+		return SelectorGroup, group, true
+	}
+	if kind, value, ok := strings.Cut(s, ":"); ok {
+		switch kind = strings.ToLower(kind); kind {
+		case SelectorGroup, SelectorTag:
+			return kind, value, true
+		}
+	}
+	return "", "", false
+}
+
+// hasTag reports whether the service carries tag, case-insensitively
+func (s Service) hasTag(tag string) bool {
+	return slices.ContainsFunc(s.Tags, func(t string) bool { return strings.EqualFold(t, tag) })
+}
+
+// selectServices returns the services a name or selector refers to, in
+// config order. Plain names match case-insensitively.
+func selectServices(config *Config, name string) []Service {
+	kind, value, isSelector := parseSelector(name)
+
+	var selected []Service
+	for _, svc := range config.Services {
+		var match bool
+		switch {
+		case !isSelector:
+			match = strings.EqualFold(svc.Name, name)
+		case kind == SelectorGroup:
+			match = svc.Group != "" && strings.EqualFold(svc.Group, value)
+		case kind == SelectorTag:
+			match = svc.hasTag(value)
+		}
+		if match {
+			selected = append(selected, svc)
+		}
+	}
+	return selected
+}
+
+// configGroups returns the group names used in config, in order of first use
+func configGroups(config *Config) []string {
+	var groups []string
+	for _, svc := range config.Services {
+		if svc.Group != "" && !slices.Contains(groups, svc.Group) {
+			groups = append(groups, svc.Group)
+		}
+	}
+	return groups
+}
+
+// filterGroup returns the statuses of services in group, case-insensitively
+func filterGroup(statuses []ServiceStatus, group string) []ServiceStatus {
+	var filtered []ServiceStatus
+	for _, st := range statuses {
+		if st.Group != "" && strings.EqualFold(st.Group, group) {
+			filtered = append(filtered, st)
+		}
+	}
+	return filtered
+}
+
+// FormatCompactCollapsed is FormatCompact with each group shown as one
+// aggregate in place of its first member, e.g. "AWS:❌3/4". Services
+// without a group are shown as usual.
+func FormatCompactCollapsed(state *State) string {
+	members := make(map[string][]ServiceStatus)
+	for _, s := range state.Services {
+		if s.Group != "" {
+			members[s.Group] = append(members[s.Group], s)
+		}
+	}
+
+	var parts []string
+	shown := make(map[string]bool)
+	for _, s := range state.Services {
+		if s.Group == "" {
+			parts = append(parts, FormatCompact(&State{Services: []ServiceStatus{s}}))
+			continue
+		}
+		if !shown[s.Group] {
+			shown[s.Group] = true
+			parts = append(parts, formatGroupAggregate(s.Group, members[s.Group]))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// formatGroupAggregate summarizes a group as "<name>:<symbol><ok>/<total>".
+// The symbol is the worst member state, ⏳ with the shortest countdown if
+// all are authenticated but some expire soon, or ✅. Disabled services
+// don't count.
+func formatGroupAggregate(group string, members []ServiceStatus) string {
+	authenticated, total := 0, 0
+	worst := StateOK
+	var soonest time.Duration
+	expiring := false
+
+	for _, s := range members {
+		state := statusState(s)
+		if state == StateDisabled {
+			continue
+		}
+		total++
+		if isAuthenticated(s) {
+			authenticated++
+			if isExpiringSoon(s) {
+				left, _ := expiresIn(s)
+				if !expiring || left < soonest {
+					soonest = left
+				}
+				expiring = true
+			}
+			continue
+		}
+		if state == StateOK {
+			state = StateUnauthenticated // expired
+		}
+		if stateRank(state) > stateRank(worst) {
+			worst = state
+		}
+	}
+
+	symbol := worst.Symbol()
+	if worst == StateOK && expiring {
+		symbol = "⏳" + formatCountdown(soonest)
+	}
+	return fmt.Sprintf("%s:%s%d/%d", group, symbol, authenticated, total)
+}
+
+// stateRank orders states by how much attention they need in an aggregate
+func stateRank(state CheckState) int {
+	switch state {
+	case StateOK, StateDisabled:
+		return 0
+	case StateUnknown, StateBlocked:
+		return 1
+	case StateTimeout:
+		return 2
+	case StateError:
+		return 3
+	}
+	return 4 // unauthenticated
+}
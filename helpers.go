@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 )
@@ -17,6 +18,11 @@ func getUserHomeDir() string {
 	return home
 }
 
+// getDefaultConfigPath returns ~/.config/gatekeeper/config.yaml
+func getDefaultConfigPath() string {
+	return filepath.Join(getUserHomeDir(), ".config", "gatekeeper", "config.yaml")
+}
+
 // getServiceIcon returns the icon for a service
 // Uses custom icon if set, otherwise returns default icon based on service name
 // Default icons use simple Unicode that works without special fonts
@@ -65,13 +71,17 @@ func getServiceIcon(serviceName, customIcon string) string {
 }
 
 // FormatCompact returns a tmux-friendly status string
-// Example: " AWS:❌  GitHub:✅"
+// Example: " AWS:❌  GitHub:✅  Okta:⏳12m"
 func FormatCompact(state *State) string {
 	var parts []string
 	for _, s := range state.Services {
-		statusIcon := "✅"
-		if !s.IsAlive {
-			statusIcon = "❌"
+		state := statusState(s)
+		statusIcon := state.Symbol()
+		if state == StateOK && isExpired(s) {
+			statusIcon = StateUnauthenticated.Symbol()
+		} else if isExpiringSoon(s) {
+			left, _ := expiresIn(s)
+			statusIcon = "⏳" + formatCountdown(left)
 		}
 
 		// Include service icon if available
@@ -84,8 +94,24 @@ func FormatCompact(state *State) string {
 	return strings.Join(parts, " ")
 }
 
+// stateLabel returns the word shown next to a state symbol
+func stateLabel(state CheckState) string {
+	switch state {
+	case StateOK:
+		return "alive"
+	case StateUnauthenticated:
+		return "dead"
+	}
+	return string(state)
+}
+
 // FormatColored returns a colored output for terminal display
 func FormatColored(state *State) string {
+	return formatDaemonHeader(state) + formatServiceLines(state.Services)
+}
+
+// formatDaemonHeader describes the daemon at the top of the colored view
+func formatDaemonHeader(state *State) string {
 	var output strings.Builder
 	
 	// Daemon status
@@ -100,23 +126,103 @@ func FormatColored(state *State) string {
 	if state.Daemon != nil && state.Daemon.Running {
 		output.WriteString(fmt.Sprintf("Last check: %s\n\n", state.Daemon.LastCheck.Format("15:04:05")))
 	}
-	
-	// Services
-	for _, s := range state.Services {
-		status := "✅ alive"
-		color := "\033[32m"  // green
-		if !s.IsAlive {
-			status = "❌ dead"
-			color = "\033[31m"  // red
+	return output.String()
+}
+
+// formatServiceLines returns one colored line per service
+func formatServiceLines(services []ServiceStatus) string {
+	var output strings.Builder
+	for _, s := range services {
+		state := statusState(s)
+		status := fmt.Sprintf("%s %s", state.Symbol(), stateLabel(state))
+		color := stateColors[state]
+		if state != StateOK {
+			if s.Error != "" {
+				status += fmt.Sprintf(" (%s)", s.Error)
+			}
+		} else if isExpired(s) {
+			status = StateUnauthenticated.Symbol() + " expired"
+			color = stateColors[StateUnauthenticated]
+		} else if isExpiringSoon(s) {
+			left, _ := expiresIn(s)
+			status = fmt.Sprintf("⏳ expires in %s", formatCountdown(left))
+			color = "\033[33m"  // yellow
+		} else if left, ok := expiresIn(s); ok {
+			status += fmt.Sprintf(", expires in %s", formatCountdown(left))
 		}
-		output.WriteString(fmt.Sprintf("%s%s\033[0m: %s\n", color, s.Name, status))
+		output.WriteString(fmt.Sprintf("%s%s\033[0m: %s%s\n", color, s.Name, status, formatFreshness(s)))
 	}
 	return output.String()
 }
 
+// FormatVerbose returns the colored output plus check details per service
+func FormatVerbose(state *State) string {
+	return formatDaemonHeader(state) + formatServiceDetails(state.Services)
+}
+
+// formatServiceDetails returns the state and check details of each service
+func formatServiceDetails(services []ServiceStatus) string {
+	var output strings.Builder
+	for _, s := range services {
+		st := statusState(s)
+		output.WriteString(fmt.Sprintf("%s%s\033[0m: %s %s\n", stateColors[st], s.Name, st.Symbol(), st))
+
+		if s.Error != "" {
+			output.WriteString(fmt.Sprintf("  error:     %s\n", s.Error))
+		}
+		if s.ExitCode != nil {
+			output.WriteString(fmt.Sprintf("  exit code: %d\n", *s.ExitCode))
+		}
+		if s.Attempt > 0 {
+			output.WriteString(fmt.Sprintf("  attempts:  %d\n", s.Attempt))
+			output.WriteString(fmt.Sprintf("  duration:  %s\n", (time.Duration(s.DurationMs) * time.Millisecond).String()))
+		}
+		if !s.LastChecked.IsZero() {
+			output.WriteString(fmt.Sprintf("  checked:   %s (%s ago)\n", s.LastChecked.Local().Format("15:04:05"), formatUptime(s.LastChecked)))
+		}
+		if !s.NextCheck.IsZero() {
+			output.WriteString(fmt.Sprintf("  next:      %s\n", s.NextCheck.Local().Format("15:04:05")))
+		}
+		if s.ExpiresAt != nil {
+			output.WriteString(fmt.Sprintf("  expires:   %s\n", s.ExpiresAt.Local().Format("2006-01-02 15:04:05")))
+		}
+		if s.Output != "" {
+			output.WriteString("  output:\n")
+			for _, line := range strings.Split(s.Output, "\n") {
+				output.WriteString(fmt.Sprintf("    \033[2m%s\033[0m\n", line))
+			}
+		}
+		output.WriteString("\n")
+	}
+	return output.String()
+}
+
+// formatFreshness describes when a service was last and will next be checked
+// Example: " (checked 12s ago, next in 18s)"
+func formatFreshness(s ServiceStatus) string {
+	if s.LastChecked.IsZero() {
+		return ""
+	}
+
+	text := fmt.Sprintf("checked %s ago", formatUptime(s.LastChecked))
+	if !s.NextCheck.IsZero() {
+		if until := time.Until(s.NextCheck); until > 0 {
+			text += fmt.Sprintf(", next in %s", formatDuration(until))
+		} else {
+			text += ", due now"
+		}
+	}
+	return fmt.Sprintf(" \033[2m(%s)\033[0m", text)
+}
+
 // formatUptime returns human-readable uptime
 func formatUptime(startTime time.Time) string {
-	duration := time.Since(startTime).Round(time.Second)
+	return formatDuration(time.Since(startTime))
+}
+
+// formatDuration returns a short human-readable duration like "1h5m" or "42s"
+func formatDuration(d time.Duration) string {
+	duration := d.Round(time.Second)
 	hours := int(duration.Hours())
 	minutes := int(duration.Minutes()) % 60
 	seconds := int(duration.Seconds()) % 60
@@ -128,3 +234,25 @@ func formatUptime(startTime time.Time) string {
 	}
 	return fmt.Sprintf("%ds", seconds)
 }
+
+// formatCountdown returns the largest unit only, for tight spaces like tmux
+// Example: "2h", "12m", "45s"
+func formatCountdown(d time.Duration) string {
+	switch {
+	case d >= 24*time.Hour:
+		return fmt.Sprintf("%dd", int(d.Hours()/24))
+	case d >= time.Hour:
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	case d >= time.Minute:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	}
+	return fmt.Sprintf("%ds", int(d.Seconds()))
+}
+
+// shellQuote quotes s as a single word for sh -c, leaving plain words as they are
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_-.,:/@%+=") == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
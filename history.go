@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultHistoryMaxSizeKB is the size at which the history file is rotated.
+// One rotated file is kept, so history uses at most twice this much.
+const DefaultHistoryMaxSizeKB = 1024
+
+// HistoryEntry is one line of history.jsonl
+type HistoryEntry struct {
+	Time       time.Time  `json:"time"`
+	Service    string     `json:"service"`
+	State      CheckState `json:"state"`
+	Previous   CheckState `json:"previous,omitempty"` // empty for the first result recorded
+	Error      string     `json:"error,omitempty"`
+	ExitCode   *int       `json:"exit_code,omitempty"`
+	DurationMs int64      `json:"duration_ms,omitempty"`
+}
+
+// IsChange reports whether the entry records a state transition
+func (e HistoryEntry) IsChange() bool {
+	return e.State != e.Previous
+}
+
+func getHistoryPath() string {
+	home := getUserHomeDir()
+	return filepath.Join(home, ".cache", "gatekeeper", "history.jsonl")
+}
+
+// historyStore appends check results to the history file. Only the
+// daemon writes to it; readers tolerate a partially written last line.
+type historyStore struct {
+	path    string
+	config  HistoryConfig
+	maxSize int64
+	last    map[string]CheckState // last recorded state per service
+}
+
+func newHistoryStore(path string, config HistoryConfig) *historyStore {
+	h := &historyStore{path: path, last: make(map[string]CheckState)}
+	h.Configure(config)
+
+	// Continue from the recorded states so a restart isn't logged as a change
+	entries, _ := readHistory(path, time.Time{})
+	for _, e := range entries {
+		h.last[e.Service] = e.State
+	}
+	return h
+}
+
+// Configure applies (possibly reloaded) history settings
+func (h *historyStore) Configure(config HistoryConfig) {
+	h.config = config
+	h.maxSize = int64(DefaultHistoryMaxSizeKB) * 1024
+	if config.MaxSizeKB > 0 {
+		h.maxSize = int64(config.MaxSizeKB) * 1024
+	}
+}
+
+// Record appends fresh check results. State changes are always recorded;
+// unchanged results only with all_results.
+func (h *historyStore) Record(statuses []ServiceStatus) error {
+	if h == nil || h.config.Disabled {
+		return nil
+	}
+
+	var buf []byte
+	for _, st := range statuses {
+		state := statusState(st)
+		previous, seen := h.last[st.Name]
+		if seen && state == previous && !h.config.AllResults {
+			continue
+		}
+		h.last[st.Name] = state
+
+		line, err := json.Marshal(HistoryEntry{
+			Time:       st.LastChecked,
+			Service:    st.Name,
+			State:      state,
+			Previous:   previous,
+			Error:      st.Error,
+			ExitCode:   st.ExitCode,
+			DurationMs: st.DurationMs,
+		})
+		if err != nil {
+			return err
+		}
+		buf = append(buf, line...)
+		buf = append(buf, '\n')
+	}
+	if len(buf) == 0 {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(h.path), 0755); err != nil {
+		return err
+	}
+	h.rotate()
+
+	f, err := os.OpenFile(h.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// rotate moves the history file aside once it reaches the size limit,
+// replacing the previous rotated file
+func (h *historyStore) rotate() {
+	info, err := os.Stat(h.path)
+	if err != nil || info.Size() < h.maxSize {
+		return
+	}
+	oldPath := h.path + ".old"
+	os.Remove(oldPath)
+	os.Rename(h.path, oldPath)
+}
+
+// readHistory returns entries at or after since, oldest first, from the
+// rotated file and the current one. Malformed lines are skipped.
+func readHistory(path string, since time.Time) ([]HistoryEntry, error) {
+	var entries []HistoryEntry
+	for _, p := range []string{path + ".old", path} {
+		f, err := os.Open(p)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			var e HistoryEntry
+			if json.Unmarshal(scanner.Bytes(), &e) != nil || e.Service == "" {
+				continue
+			}
+			if !e.Time.Before(since) {
+				entries = append(entries, e)
+			}
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Time.Before(entries[j].Time)
+	})
+	return entries, nil
+}
+
+// ServiceHistory summarizes one service's history over a time window
+type ServiceHistory struct {
+	Name          string         `json:"name"`
+	UptimePercent *float64       `json:"uptime_percent"` // null when no state is known in the window
+	Changes       int            `json:"changes"`
+	Entries       []HistoryEntry `json:"entries"`
+}
+
+// summarizeHistory groups entries by service, in order of first appearance.
+// entries must include those before since, which give the state each
+// service was in when the window starts.
+func summarizeHistory(entries []HistoryEntry, since, until time.Time) []ServiceHistory {
+	var order []string
+	byName := make(map[string][]HistoryEntry)
+	for _, e := range entries {
+		if _, ok := byName[e.Service]; !ok {
+			order = append(order, e.Service)
+		}
+		byName[e.Service] = append(byName[e.Service], e)
+	}
+
+	var result []ServiceHistory
+	for _, name := range order {
+		h := ServiceHistory{Name: name, Entries: []HistoryEntry{}}
+		for _, e := range byName[name] {
+			if e.Time.Before(since) {
+				continue
+			}
+			h.Entries = append(h.Entries, e)
+			if e.IsChange() {
+				h.Changes++
+			}
+		}
+		h.UptimePercent = uptimePercent(byName[name], since, until)
+		result = append(result, h)
+	}
+	return result
+}
+
+// uptimePercent returns the share of the window spent in the ok state.
+// Each recorded state is assumed to hold until the next entry. Time in
+// the unknown or disabled states, or before the first entry, is not counted.
+func uptimePercent(entries []HistoryEntry, since, until time.Time) *float64 {
+	var okTime, knownTime time.Duration
+	for i, e := range entries {
+		start := e.Time
+		end := until
+		if i+1 < len(entries) {
+			end = entries[i+1].Time
+		}
+		if start.Before(since) {
+			start = since
+		}
+		if end.After(until) {
+			end = until
+		}
+		if !end.After(start) || e.State == StateUnknown || e.State == StateDisabled {
+			continue
+		}
+
+		knownTime += end.Sub(start)
+		if e.State == StateOK {
+			okTime += end.Sub(start)
+		}
+	}
+
+	if knownTime == 0 {
+		return nil
+	}
+	pct := 100 * float64(okTime) / float64(knownTime)
+	return &pct
+}
+
+// parseSince accepts a duration ago ("24h", "7d") or a date or timestamp
+func parseSince(value string, now time.Time) (time.Time, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n >= 0 {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid --since %q (use e.g. 24h, 7d or 2006-01-02)", value)
+}
+
+// FormatHistory renders a per-service timeline of state changes
+func FormatHistory(histories []ServiceHistory, since time.Time) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("History since %s\n", since.Local().Format("2006-01-02 15:04")))
+
+	if len(histories) == 0 {
+		sb.WriteString("\nNo checks recorded\n")
+		return sb.String()
+	}
+
+	for _, h := range histories {
+		uptime := "n/a"
+		if h.UptimePercent != nil {
+			uptime = fmt.Sprintf("%.1f%%", *h.UptimePercent)
+		}
+		changes := "changes"
+		if h.Changes == 1 {
+			changes = "change"
+		}
+		sb.WriteString(fmt.Sprintf("\n%s: %s uptime, %d %s\n", h.Name, uptime, h.Changes, changes))
+
+		for _, e := range h.Entries {
+			if !e.IsChange() {
+				continue
+			}
+			line := fmt.Sprintf("  %s  %s %s", e.Time.Local().Format("2006-01-02 15:04:05"), e.State.Symbol(), e.State)
+			if e.Error != "" {
+				line += " (" + e.Error + ")"
+			}
+			sb.WriteString(line + "\n")
+		}
+	}
+	return sb.String()
+}
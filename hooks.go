@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+const (
+	DefaultHookDebounce = 2
+	DefaultHookTimeout  = 30 * time.Second
+)
+
+// Hook events, passed to hooks as GATEKEEPER_EVENT
+const (
+	HookFail    = "fail"
+	HookRecover = "recover"
+	HookChange  = "change"
+)
+
+// Transition is a confirmed change of a service's state
+type Transition struct {
+	Service string
+	From    CheckState
+	To      CheckState
+	Status  ServiceStatus // the result that confirmed the change
+}
+
+// Events returns the hook events a transition triggers, most specific first
+func (t Transition) Events() []string {
+	var events []string
+	switch {
+	case t.From == StateOK && isFailing(t.To):
+		events = append(events, HookFail)
+	case isFailing(t.From) && t.To == StateOK:
+		events = append(events, HookRecover)
+	}
+	return append(events, HookChange)
+}
+
+// isFailing reports whether a state means the check ran and did not pass
+func isFailing(state CheckState) bool {
+	return state == StateUnauthenticated || state == StateTimeout || state == StateError
+}
+
+// serviceTracker debounces the results of one service
+type serviceTracker struct {
+	confirmed CheckState // last state that counted as a change
+	pending   CheckState // different state seen in the latest results
+	count     int        // results in a row with the pending state
+}
+
+// transitionTracker turns check results into debounced transitions. A new
+// state has to be seen in debounce consecutive results before it counts,
+// so one flaky check doesn't look like a change.
+type transitionTracker struct {
+	services map[string]*serviceTracker
+}
+
+// newTransitionTracker starts from the states in previous, so changes
+// that happened while the daemon was stopped are still noticed
+func newTransitionTracker(previous *State) *transitionTracker {
+	t := &transitionTracker{services: make(map[string]*serviceTracker)}
+	if previous != nil {
+		for _, st := range previous.Services {
+			if state := statusState(st); state != StateUnknown {
+				t.services[st.Name] = &serviceTracker{confirmed: state}
+			}
+		}
+	}
+	return t
+}
+
+// Observe records a result and returns the transition it confirms, if any.
+// Unknown results (interrupted checks) are ignored.
+func (t *transitionTracker) Observe(status ServiceStatus, debounce int) (Transition, bool) {
+	state := statusState(status)
+	if state == StateUnknown {
+		return Transition{}, false
+	}
+
+	s, ok := t.services[status.Name]
+	if !ok {
+		// First result for this service; nothing to compare against
+		t.services[status.Name] = &serviceTracker{confirmed: state}
+		return Transition{}, false
+	}
+
+	if state == s.confirmed {
+		s.pending, s.count = "", 0
+		return Transition{}, false
+	}
+	if state == s.pending {
+		s.count++
+	} else {
+		s.pending, s.count = state, 1
+	}
+	if s.count < max(debounce, 1) {
+		return Transition{}, false
+	}
+
+	tr := Transition{Service: status.Name, From: s.confirmed, To: state, Status: status}
+	s.confirmed, s.pending, s.count = state, "", 0
+	return tr, true
+}
+
+// ObserveAll feeds fresh results to the tracker, using each service's
+// hook_debounce, and returns the transitions they confirm
+func (t *transitionTracker) ObserveAll(config *Config, statuses []ServiceStatus) []Transition {
+	var transitions []Transition
+	for _, st := range statuses {
+		svc, ok := findService(config, st.Name)
+		if !ok {
+			continue
+		}
+
+		debounce := DefaultHookDebounce
+		if config.HookDebounce > 0 {
+			debounce = config.HookDebounce
+		}
+		if svc.HookDebounce > 0 {
+			debounce = svc.HookDebounce
+		}
+
+		if tr, ok := t.Observe(st, debounce); ok {
+			transitions = append(transitions, tr)
+		}
+	}
+	return transitions
+}
+
+// hookRunner runs the configured hooks for transitions
+type hookRunner struct {
+	logger *Logger
+}
+
+func newHookRunner(logger *Logger) *hookRunner {
+	return &hookRunner{logger: logger}
+}
+
+// Run starts the hooks for each transition in the background
+func (h *hookRunner) Run(config *Config, transitions []Transition) {
+	for _, tr := range transitions {
+		svc, ok := findService(config, tr.Service)
+		if !ok {
+			continue
+		}
+
+		commands := hookCommands(config.Hooks, svc.Hooks, tr.Events())
+		if len(commands) == 0 {
+			continue
+		}
+
+		timeout := DefaultHookTimeout
+		if config.HookTimeout > 0 {
+			timeout = time.Duration(config.HookTimeout) * time.Second
+		}
+		if svc.HookTimeout > 0 {
+			timeout = time.Duration(svc.HookTimeout) * time.Second
+		}
+
+		go h.run(tr, commands, timeout)
+	}
+}
+
+// hookCommand is a hook command and the event it was configured for
+type hookCommand struct {
+	event string
+	cmd   string
+}
+
+// hookCommands lists the commands for the given events, global hooks
+// before the service's own
+func hookCommands(global, service Hooks, events []string) []hookCommand {
+	var commands []hookCommand
+	for _, event := range events {
+		for _, hooks := range []Hooks{global, service} {
+			if cmd := hooks.command(event); cmd != "" {
+				commands = append(commands, hookCommand{event: event, cmd: cmd})
+			}
+		}
+	}
+	return commands
+}
+
+func (h Hooks) command(event string) string {
+	switch event {
+	case HookFail:
+		return h.OnFail
+	case HookRecover:
+		return h.OnRecover
+	case HookChange:
+		return h.OnChange
+	}
+	return ""
+}
+
+// run executes the hooks for a transition one after another
+func (h *hookRunner) run(tr Transition, commands []hookCommand, timeout time.Duration) {
+	for _, c := range commands {
+		output, err := runHook(c.cmd, hookEnv(tr, c.event), timeout)
+		if err != nil {
+			h.logger.Warnf("[%s] on_%s hook failed: %v%s", tr.Service, c.event, err, formatHookOutput(output))
+			continue
+		}
+		h.logger.Infof("[%s] on_%s hook ran", tr.Service, c.event)
+	}
+}
+
+// hookEnv describes the transition to the hook command
+func hookEnv(tr Transition, event string) []string {
+	env := []string{
+		"GATEKEEPER_EVENT=" + event,
+		"GATEKEEPER_SERVICE=" + tr.Service,
+		"GATEKEEPER_OLD_STATE=" + string(tr.From),
+		"GATEKEEPER_NEW_STATE=" + string(tr.To),
+		"GATEKEEPER_ERROR=" + tr.Status.Error,
+	}
+	expiresAt := ""
+	if tr.Status.ExpiresAt != nil {
+		expiresAt = tr.Status.ExpiresAt.Format(time.RFC3339)
+	}
+	env = append(env, "GATEKEEPER_EXPIRES_AT="+expiresAt)
+	if tr.Status.ExitCode != nil {
+		env = append(env, "GATEKEEPER_EXIT_CODE="+strconv.Itoa(*tr.Status.ExitCode))
+	}
+	return env
+}
+
+// runHook runs cmdStr through bash with env added to the daemon's environment.
+// Unlike checks the command is not pre-expanded, so it can use the
+// GATEKEEPER_* variables.
+func runHook(cmdStr string, env []string, timeout time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	output := &tailBuffer{max: maxOutputTailBytes}
+	cmd := exec.CommandContext(ctx, "bash", "-c", cmdStr)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = output
+	cmd.Stderr = output
+
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return output.String(), fmt.Errorf("timed out after %s", timeout)
+	}
+	return output.String(), err
+}
+
+func formatHookOutput(output string) string {
+	if summary := summarizeOutput(output); summary != "" {
+		return "\n" + summary
+	}
+	return ""
+}
+
+// findService returns the configured service with the given name
+func findService(config *Config, name string) (Service, bool) {
+	for _, svc := range config.Services {
+		if svc.Name == name {
+			return svc, true
+		}
+	}
+	return Service{}, false
+}
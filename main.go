@@ -1,14 +1,20 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"slices"
 	"strings"
+	"syscall"
 	"time"
 )
 
@@ -17,9 +23,20 @@ func main() {
 	statusCmd := flag.NewFlagSet("status", flag.ExitOnError)
 	jsonFlag := statusCmd.Bool("json", false, "Output as JSON")
 	compactFlag := statusCmd.Bool("compact", false, "Compact output for tmux")
+	verboseFlag := statusCmd.Bool("verbose", false, "Show exit codes, durations and command output")
+	groupFlag := statusCmd.String("group", "", "Only show services in this group")
+	collapseFlag := statusCmd.Bool("collapse", false, "With --compact, show each group as one aggregate")
 
 	daemonCmd := flag.NewFlagSet("daemon", flag.ExitOnError)
 	configPath := daemonCmd.String("config", "", "Path to config file (default: ~/.config/gatekeeper/config.yaml)")
+	lenientFlag := daemonCmd.Bool("lenient", false, "Start even if the config has validation errors")
+	textfileFlag := daemonCmd.Bool("textfile", false, "Write Prometheus metrics to gatekeeper.prom next to state.json")
+
+	authCmd := flag.NewFlagSet("auth", flag.ExitOnError)
+	exactFlag := authCmd.Bool("exact", false, "Only match the service with exactly this name (case-insensitive)")
+
+	validateCmd := flag.NewFlagSet("validate", flag.ExitOnError)
+	validateConfigPath := validateCmd.String("config", "", "Path to config file (default: ~/.config/gatekeeper/config.yaml)")
 
 	if len(os.Args) < 2 {
 		printUsage()
@@ -29,7 +46,7 @@ func main() {
 	switch os.Args[1] {
 	case "status":
 		statusCmd.Parse(os.Args[2:])
-		handleStatus(*jsonFlag, *compactFlag)
+		handleStatus(*jsonFlag, *compactFlag, *verboseFlag, *groupFlag, *collapseFlag)
 
 	case "start":
 		daemonCmd.Parse(os.Args[2:])
@@ -37,15 +54,34 @@ func main() {
 		// Use default config path if not specified
 		configFile := *configPath
 		if configFile == "" {
-			home := getUserHomeDir()
-			configFile = filepath.Join(home, ".config/gatekeeper/config.yaml")
+			configFile = getDefaultConfigPath()
 		}
-		
-		config, err := loadConfig(configFile)
+
+		config, warning, err := loadDaemonConfig(configFile, *lenientFlag)
 		if err != nil {
+			var cfgErr *ConfigError
+			if errors.As(err, &cfgErr) {
+				log.Fatalf("Error loading config from %s: %v\nRun 'gatekeeper validate' for details or start with --lenient to ignore.", configFile, err)
+			}
 			log.Fatalf("Error loading config from %s: %v", configFile, err)
 		}
-		runDaemon(config)
+		if warning != nil {
+			log.Printf("Warning: %v", warning)
+		}
+		runDaemon(configFile, config, *lenientFlag, *textfileFlag)
+
+	case "validate":
+		validateCmd.Parse(os.Args[2:])
+
+		// Accept the path either via --config or as a positional argument
+		configFile := *validateConfigPath
+		if configFile == "" && validateCmd.NArg() > 0 {
+			configFile = validateCmd.Arg(0)
+		}
+		if configFile == "" {
+			configFile = getDefaultConfigPath()
+		}
+		handleValidate(configFile)
 
 	case "init":
 		handleInit()
@@ -53,12 +89,35 @@ func main() {
 	case "stop":
 		handleStop()
 
+	case "reload":
+		handleReload()
+
 	case "auth":
+		authCmd.Parse(os.Args[2:])
+		if authCmd.NArg() < 1 {
+			fmt.Println("Usage: gatekeeper auth [--exact] <service-name|all>")
+			os.Exit(1)
+		}
+		handleAuth(authCmd.Arg(0), *exactFlag)
+
+	case "check":
+		handleCheck(os.Args[2:])
+
+	case "run":
+		handleRun(os.Args[2:])
+
+	case "wait":
+		handleWait(os.Args[2:])
+
+	case "history":
+		handleHistory(os.Args[2:])
+
+	case "schema":
 		if len(os.Args) < 3 {
-			fmt.Println("Usage: gatekeeper auth <service-name|all>")
+			fmt.Println("Usage: gatekeeper schema state")
 			os.Exit(1)
 		}
-		handleAuth(os.Args[2])
+		handleSchema(os.Args[2])
 
 	case "completion":
 		if len(os.Args) < 3 {
@@ -73,22 +132,54 @@ func main() {
 	}
 }
 
-func handleStatus(jsonOutput, compact bool) {
+func handleStatus(jsonOutput, compact, verbose bool, group string, collapse bool) {
 	state, err := loadState()
 	if err != nil {
 		log.Fatalf("Error loading state: %v", err)
 	}
 
+	if group != "" {
+		state.Services = filterGroup(state.Services, group)
+		if len(state.Services) == 0 {
+			fmt.Fprintf(os.Stderr, "No services in group: %s\n", group)
+			os.Exit(1)
+		}
+	}
+
 	if jsonOutput {
 		data, _ := json.MarshalIndent(state, "", "  ")
 		fmt.Println(string(data))
+	} else if compact && collapse {
+		fmt.Println(FormatCompactCollapsed(state))
 	} else if compact {
 		fmt.Println(FormatCompact(state))
+	} else if verbose {
+		fmt.Print(FormatVerbose(state))
 	} else {
 		fmt.Print(FormatColored(state))
 	}
 }
 
+func handleValidate(configFile string) {
+	_, err := loadConfigStrict(configFile)
+	if err == nil {
+		fmt.Printf("✓ %s is valid\n", configFile)
+		return
+	}
+
+	var cfgErr *ConfigError
+	if !errors.As(err, &cfgErr) {
+		fmt.Printf("%s: %v\n", configFile, err)
+		os.Exit(1)
+	}
+
+	for _, issue := range cfgErr.Issues {
+		fmt.Println(issue)
+	}
+	fmt.Printf("\n%d problem(s) found\n", len(cfgErr.Issues))
+	os.Exit(1)
+}
+
 func handleInit() {
 	home := getUserHomeDir()
 	configPath := filepath.Join(home, ".config", "gatekeeper", "config.yaml")
@@ -119,22 +210,31 @@ interval: 30
 }
 
 func handleStop() {
-	home := getUserHomeDir()
-	pidFile := filepath.Join(home, ".cache/gatekeeper/daemon.pid")
+	pidFile := getPIDPath()
 
-	pidBytes, err := os.ReadFile(pidFile)
-	if err != nil {
+	pid, err := readDaemonPID()
+	if os.IsNotExist(err) {
 		fmt.Println("Daemon not running (no PID file found)")
 		return
 	}
-
-	var pid int
-	if _, err := fmt.Sscanf(string(pidBytes), "%d", &pid); err != nil || pid <= 0 {
+	if err != nil {
 		fmt.Println("Invalid PID file")
 		os.Remove(pidFile)
 		return
 	}
 
+	// Prefer the control socket: it answers once the daemon has shut down
+	if conn, err := dialControl(); err == nil {
+		conn.Close()
+		fmt.Printf("Stopping daemon (PID %d)...\n", pid)
+		if err := requestStop(3 * time.Second); err == nil {
+			fmt.Println("Daemon stopped successfully")
+			return
+		}
+	}
+
+	// Fall back to signals for daemons without a control socket
+
 	// Find the process
 	process, err := os.FindProcess(pid)
 	if err != nil {
@@ -169,7 +269,42 @@ func handleStop() {
 	fmt.Println("Daemon stopped (forced)")
 }
 
-func handleAuth(serviceName string) {
+func handleReload() {
+	// Prefer the control socket, which reports whether the new config was accepted
+	if conn, err := dialControl(); err == nil {
+		conn.Close()
+		resp, err := sendControl(ControlRequest{Cmd: ControlReload}, 2*time.Minute)
+		if err != nil {
+			fmt.Printf("Reload failed, daemon keeps previous config:\n%v\n", err)
+			os.Exit(1)
+		}
+		count := 0
+		if resp.State != nil {
+			count = len(resp.State.Services)
+		}
+		fmt.Printf("✓ Config reloaded (%d services)\n", count)
+		return
+	}
+
+	pid, err := readDaemonPID()
+	if err != nil {
+		fmt.Println("Daemon not running (no PID file found)")
+		os.Exit(1)
+	}
+
+	process, err := os.FindProcess(pid)
+	if err == nil {
+		err = process.Signal(syscall.SIGHUP)
+	}
+	if err != nil {
+		fmt.Printf("Error signaling daemon (PID %d): %v\n", pid, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Reload requested (PID %d), see ~/.cache/gatekeeper/gatekeeper.log for the result\n", pid)
+}
+
+func handleAuth(serviceName string, exact bool) {
 	// Load config
 	home := getUserHomeDir()
 	configFile := filepath.Join(home, ".config/gatekeeper/config.yaml")
@@ -184,8 +319,21 @@ func handleAuth(serviceName string) {
 	searchLower := strings.ToLower(serviceName)
 
 	// Special case: "all" matches all services with auth_cmd
-	if searchLower == "all" {
+	if exact {
 		for _, svc := range config.Services {
+			if strings.EqualFold(svc.Name, serviceName) && svc.AuthCmd != "" {
+				matchedServices = append(matchedServices, svc)
+			}
+		}
+	} else if searchLower == "all" {
+		for _, svc := range config.Services {
+			if svc.AuthCmd != "" {
+				matchedServices = append(matchedServices, svc)
+			}
+		}
+	} else if _, _, ok := parseSelector(serviceName); ok {
+		// @group or tag:name
+		for _, svc := range selectServices(config, serviceName) {
 			if svc.AuthCmd != "" {
 				matchedServices = append(matchedServices, svc)
 			}
@@ -213,6 +361,20 @@ func handleAuth(serviceName string) {
 		os.Exit(1)
 	}
 
+	// Log in to services before those that depend on them
+	ordered, err := dependencyOrder(withDependencies(config.Services, matchedServices))
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	matched := matchedServices
+	matchedServices = nil
+	for _, svc := range ordered {
+		if slices.ContainsFunc(matched, func(m Service) bool { return m.Name == svc.Name }) {
+			matchedServices = append(matchedServices, svc)
+		}
+	}
+
 	// Execute auth for all matched services
 	if len(matchedServices) == 1 {
 		fmt.Printf("Running auth for '%s'...\n", matchedServices[0].Name)
@@ -224,20 +386,31 @@ func handleAuth(serviceName string) {
 		fmt.Println("\nRunning auth for all...")
 	}
 
+	var authed []Service
+	var failedNames []string
+	failed := 0
 	for i, svc := range matchedServices {
 		if len(matchedServices) > 1 {
 			fmt.Printf("\n[%d/%d] Authenticating '%s'...\n", i+1, len(matchedServices), svc.Name)
 		}
 
-		cmd := exec.Command("sh", "-c", svc.AuthCmd)
-		cmd.Stdin = os.Stdin
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
+		// No point logging in behind a dependency whose login just failed
+		if j := slices.IndexFunc(svc.DependsOn, func(dep string) bool {
+			return slices.ContainsFunc(failedNames, func(n string) bool { return strings.EqualFold(n, dep) })
+		}); j >= 0 {
+			fmt.Printf("Skipping '%s': depends on '%s', whose auth failed\n", svc.Name, svc.DependsOn[j])
+			failedNames = append(failedNames, svc.Name)
+			failed++
+			continue
+		}
 
-		if err := cmd.Run(); err != nil {
+		if err := runAuthCmd(svc, os.Stdout); err != nil {
 			fmt.Printf("Auth failed for '%s': %v\n", svc.Name, err)
+			failedNames = append(failedNames, svc.Name)
+			failed++
 			continue
 		}
+		authed = append(authed, svc)
 
 		if len(matchedServices) > 1 {
 			fmt.Printf("✓ Auth completed for '%s'\n", svc.Name)
@@ -245,12 +418,470 @@ func handleAuth(serviceName string) {
 	}
 
 	if len(matchedServices) == 1 {
-		fmt.Printf("Auth completed for '%s'\n", matchedServices[0].Name)
-	} else {
+		if failed == 0 {
+			fmt.Printf("Auth completed for '%s'\n", matchedServices[0].Name)
+		}
+	} else if failed == 0 {
 		fmt.Printf("\n✓ All auth commands completed\n")
+	} else {
+		fmt.Printf("\n%d of %d auth commands failed\n", failed, len(matchedServices))
+	}
+
+	// Verify the login actually worked
+	if len(authed) > 0 && !verifyAuth(config, authed) {
+		failed++
+	}
+
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// runAuthCmd runs a service's auth_cmd attached to the terminal
+func runAuthCmd(svc Service, stdout io.Writer) error {
+	cmd := exec.Command("sh", "-c", svc.AuthCmd)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// verifyAuth re-checks services right after auth so status reflects a fresh
+// login without waiting for the next interval.
+// Returns false if any service is still not alive.
+func verifyAuth(config *Config, services []Service) bool {
+	fmt.Println("\nVerifying...")
+	results := checkNow(config, services)
+
+	allAlive := true
+	for _, st := range results {
+		state := statusState(st)
+		switch {
+		case state == StateOK:
+			fmt.Printf("  %s %s: logged in\n", state.Symbol(), st.Name)
+		case st.Error != "":
+			fmt.Printf("  %s %s: still %s (%s)\n", state.Symbol(), st.Name, state, st.Error)
+			allAlive = false
+		default:
+			fmt.Printf("  %s %s: still %s\n", state.Symbol(), st.Name, state)
+			allAlive = false
+		}
+	}
+	return allAlive
+}
+
+// handleCheck checks services in the foreground, without the daemon, and
+// exits with ExitOK, ExitUnauthenticated or ExitCheckError
+func handleCheck(args []string) {
+	checkCmd := flag.NewFlagSet("check", flag.ExitOnError)
+	configPath := checkCmd.String("config", "", "Path to config file (default: ~/.config/gatekeeper/config.yaml)")
+	jsonFlag := checkCmd.Bool("json", false, "Output as JSON")
+	compactFlag := checkCmd.Bool("compact", false, "Compact output for tmux")
+	verboseFlag := checkCmd.Bool("verbose", false, "Show exit codes, durations and command output")
+	failFast := checkCmd.Bool("fail-fast", false, "Stop at the first service that isn't ok")
+	updateFlag := checkCmd.Bool("update-state", false, "Save the results to state.json")
+	names := parseInterspersed(checkCmd, args)
+
+	configFile := *configPath
+	if configFile == "" {
+		configFile = getDefaultConfigPath()
+	}
+	config, err := loadConfig(configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config from %s: %v\n", configFile, err)
+		os.Exit(ExitCheckError)
+	}
+
+	only, err := resolveServiceNames(config, names)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(ExitCheckError)
+	}
+	services := config.Services
+	if only != nil {
+		services = filterServices(config.Services, only)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	checker := NewEnhancedChecker(CheckerOptions{
+		ExpiryWarning: time.Duration(config.ExpiryWarning) * time.Second,
+	})
+	stopped := false
+	all := checker.CheckBatchFunc(ctx, withDependencies(config.Services, services), func(st ServiceStatus) {
+		if *failFast && checkExitCode([]ServiceStatus{st}, false) != ExitOK {
+			stopped = true
+			cancel()
+		}
+	})
+
+	// Dependencies were checked too, but only the requested services are shown
+	var results []ServiceStatus
+	for _, st := range all {
+		if slices.ContainsFunc(services, func(svc Service) bool { return svc.Name == st.Name }) {
+			results = append(results, st)
+		}
+	}
+
+	if *updateFlag {
+		// Checks abandoned by --fail-fast tell nothing new
+		var saved []ServiceStatus
+		for _, st := range all {
+			if !stopped || statusState(st) != StateUnknown {
+				saved = append(saved, st)
+			}
+		}
+		err := updateState(func(state *State) {
+			state.Services = mergeStatuses(config.Services, saved, state)
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: could not update state: %v\n", err)
+		}
+	}
+
+	state := &State{SchemaVersion: StateSchemaVersion, Services: results}
+	if *jsonFlag {
+		data, _ := json.MarshalIndent(state, "", "  ")
+		fmt.Println(string(data))
+	} else if *compactFlag {
+		fmt.Println(FormatCompact(state))
+	} else if *verboseFlag {
+		fmt.Print(formatServiceDetails(results))
+	} else {
+		fmt.Print(formatServiceLines(results))
+	}
+
+	os.Exit(checkExitCode(results, stopped))
+}
+
+// parseInterspersed parses flags that may come before, between or after
+// positional arguments and returns the positional ones
+func parseInterspersed(fs *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		fs.Parse(args)
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// handleRun runs a command once the required services are authenticated,
+// offering to run auth_cmd for those that aren't, and exits with its code
+func handleRun(args []string) {
+	runCmd := flag.NewFlagSet("run", flag.ExitOnError)
+	configPath := runCmd.String("config", "", "Path to config file (default: ~/.config/gatekeeper/config.yaml)")
+	requireFlag := runCmd.String("require", "", "Comma-separated services that must be authenticated (default: all enabled)")
+	maxAge := runCmd.Duration("max-age", 0, "Trust results in state.json up to this old (e.g. 5m) instead of checking now")
+	yesFlag := runCmd.Bool("yes", false, "Run auth_cmd for failing services without asking")
+	noAuthFlag := runCmd.Bool("no-auth", false, "Fail instead of offering to authenticate")
+	runCmd.Parse(args)
+
+	argv := runCmd.Args()
+	if len(argv) == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: gatekeeper run [--require svc1,svc2] [--max-age 5m] -- command [args...]")
+		os.Exit(ExitCheckError)
+	}
+
+	configFile := *configPath
+	if configFile == "" {
+		configFile = getDefaultConfigPath()
+	}
+	config, err := loadConfig(configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config from %s: %v\n", configFile, err)
+		os.Exit(ExitCheckError)
+	}
+
+	var requested []string
+	for _, name := range strings.Split(*requireFlag, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			requested = append(requested, name)
+		}
+	}
+	only, err := resolveServiceNames(config, requested)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(ExitCheckError)
+	}
+	candidates := config.Services
+	if only != nil {
+		candidates = filterServices(config.Services, only)
+	}
+	var services []Service
+	for _, svc := range candidates {
+		if svc.Disabled {
+			fmt.Fprintf(os.Stderr, "Warning: %s is disabled, not checking it\n", svc.Name)
+			continue
+		}
+		services = append(services, svc)
+	}
+
+	// Dependencies have to be authenticated too
+	services = withDependencies(config.Services, services)
+
+	// Each round logs in to the failing services that aren't blocked. A
+	// dependency's login can reveal that its dependents need one too.
+	failing := unreadyServices(config, services, *maxAge)
+	authed := make(map[string]bool)
+	for len(failing) > 0 {
+		fmt.Fprintln(os.Stderr, "Not authenticated:")
+		var toAuth []Service
+		canAuth := !*noAuthFlag
+		for _, st := range failing {
+			line := fmt.Sprintf("  %s %s: %s", statusState(st).Symbol(), st.Name, statusState(st))
+			if isExpired(st) {
+				line = fmt.Sprintf("  %s %s: expired", StateUnauthenticated.Symbol(), st.Name)
+			} else if st.Error != "" {
+				line += fmt.Sprintf(" (%s)", st.Error)
+			}
+			fmt.Fprintln(os.Stderr, line)
+			if statusState(st) == StateBlocked {
+				continue // fixed by logging in to its dependency
+			}
+
+			// Only offer auth if it can fix every failing service,
+			// and give up on one that is still failing after its auth
+			svc, ok := findService(config, st.Name)
+			if !ok || svc.AuthCmd == "" || authed[svc.Name] {
+				canAuth = false
+			}
+			toAuth = append(toAuth, svc)
+		}
+
+		if canAuth && len(toAuth) > 0 && !*yesFlag {
+			names := make([]string, len(toAuth))
+			for i, svc := range toAuth {
+				names[i] = svc.Name
+			}
+			canAuth = isInteractive() && confirm(fmt.Sprintf("Run auth for %s?", strings.Join(names, ", ")))
+		}
+		if !canAuth || len(toAuth) == 0 {
+			fmt.Fprintf(os.Stderr, "Not running %s\n", argv[0])
+			os.Exit(exitRunRefused)
+		}
+
+		if ordered, err := dependencyOrder(toAuth); err == nil {
+			toAuth = ordered
+		}
+		for _, svc := range toAuth {
+			authed[svc.Name] = true
+			fmt.Fprintf(os.Stderr, "Authenticating '%s'...\n", svc.Name)
+			if err := runAuthCmd(svc, os.Stderr); err != nil {
+				fmt.Fprintf(os.Stderr, "Auth failed for '%s': %v\n", svc.Name, err)
+			}
+		}
+		failing = unreadyServices(config, services, 0)
+	}
+
+	os.Exit(runChild(argv))
+}
+
+// handleWait blocks until the services reach the wanted state, following
+// the daemon if one is running and checking directly otherwise
+func handleWait(args []string) {
+	waitCmd := flag.NewFlagSet("wait", flag.ExitOnError)
+	configPath := waitCmd.String("config", "", "Path to config file (default: ~/.config/gatekeeper/config.yaml)")
+	timeout := waitCmd.Duration("timeout", 0, "Give up after this long, e.g. 5m (default: wait forever)")
+	stateFlag := waitCmd.String("state", string(StateOK), "State to wait for")
+	interval := waitCmd.Duration("interval", DefaultWaitInterval, "How often to re-check")
+	names := parseInterspersed(waitCmd, args)
+
+	want := CheckState(strings.ToLower(*stateFlag))
+	if !isValidCheckState(want) {
+		fmt.Fprintf(os.Stderr, "Error: unknown state %q\n", *stateFlag)
+		os.Exit(ExitCheckError)
+	}
+	if *interval <= 0 {
+		fmt.Fprintln(os.Stderr, "Error: --interval must be positive")
+		os.Exit(ExitCheckError)
+	}
+
+	configFile := *configPath
+	if configFile == "" {
+		configFile = getDefaultConfigPath()
+	}
+	config, err := loadConfig(configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config from %s: %v\n", configFile, err)
+		os.Exit(ExitCheckError)
+	}
+	only, err := resolveServiceNames(config, names)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(ExitCheckError)
+	}
+	services := config.Services
+	if only != nil {
+		services = filterServices(config.Services, only)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	w := newWaitTracker(services, want)
+	fmt.Fprintf(os.Stderr, "Waiting for %s to be %s\n", describeServices(only), want)
+
+	daemonRunning := false
+	if pid, err := readDaemonPID(); err == nil {
+		daemonRunning = isProcessRunning(pid)
+	}
+	conn, dialErr := dialControl()
+	if dialErr == nil {
+		conn.Close()
+		err = waitWithDaemon(ctx, w, *interval)
+		if err != nil && ctx.Err() == nil {
+			// The daemon went away; carry on without it
+			fmt.Fprintf(os.Stderr, "Warning: %v, checking directly\n", err)
+			err = waitWithChecks(ctx, config, w, *interval)
+		}
+	} else if daemonRunning {
+		err = waitOnStateFile(ctx, w)
+	} else {
+		err = waitWithChecks(ctx, config, w, *interval)
+	}
+
+	switch {
+	case err == nil:
+		return
+	case errors.Is(err, context.DeadlineExceeded):
+		fmt.Fprintf(os.Stderr, "Timed out after %s, still waiting for: %s\n", *timeout, strings.Join(w.Pending(), ", "))
+		os.Exit(1)
+	case errors.Is(err, context.Canceled):
+		os.Exit(130)
+	default:
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(ExitCheckError)
 	}
 }
 
+// checkNow checks services right away. A running daemon does the check
+// itself; otherwise it runs here and the result goes into state.json.
+func checkNow(config *Config, services []Service) []ServiceStatus {
+	names := make([]string, len(services))
+	for i, svc := range services {
+		names[i] = svc.Name
+	}
+
+	resp, err := sendControl(ControlRequest{Cmd: ControlCheck, Services: names}, 2*time.Minute)
+	if err == nil && resp.State != nil {
+		var results []ServiceStatus
+		for _, st := range resp.State.Services {
+			if slices.Contains(names, st.Name) {
+				results = append(results, st)
+			}
+		}
+		return results
+	}
+
+	checker := NewEnhancedChecker(CheckerOptions{
+		ExpiryWarning: time.Duration(config.ExpiryWarning) * time.Second,
+	})
+	all := checker.CheckBatch(context.Background(), withDependencies(config.Services, services))
+
+	err = updateState(func(state *State) {
+		state.Services = mergeStatuses(config.Services, all, state)
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not update state: %v\n", err)
+	}
+
+	var results []ServiceStatus
+	for _, st := range all {
+		if slices.Contains(names, st.Name) {
+			results = append(results, st)
+		}
+	}
+	return results
+}
+
+func handleHistory(args []string) {
+	historyCmd := flag.NewFlagSet("history", flag.ExitOnError)
+	sinceFlag := historyCmd.String("since", "24h", "Show history since a duration ago (24h, 7d) or a date")
+	jsonFlag := historyCmd.Bool("json", false, "Output as JSON")
+
+	// The service name may come before or after the flags
+	var serviceName string
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		serviceName, args = args[0], args[1:]
+	}
+	historyCmd.Parse(args)
+	if serviceName == "" && historyCmd.NArg() > 0 {
+		serviceName = historyCmd.Arg(0)
+	}
+
+	now := time.Now()
+	since, err := parseSince(*sinceFlag, now)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Read everything so uptime knows each service's state at the start of the window
+	entries, err := readHistory(getHistoryPath(), time.Time{})
+	if err != nil {
+		log.Fatalf("Error reading history: %v", err)
+	}
+
+	if serviceName != "" {
+		// @group and tag:name need the config to know the members
+		names := []string{serviceName}
+		if _, _, ok := parseSelector(serviceName); ok {
+			names = nil
+			if config, err := loadConfig(getDefaultConfigPath()); err == nil {
+				for _, svc := range selectServices(config, serviceName) {
+					names = append(names, svc.Name)
+				}
+			}
+		}
+
+		var filtered []HistoryEntry
+		for _, e := range entries {
+			if slices.ContainsFunc(names, func(n string) bool { return strings.EqualFold(e.Service, n) }) {
+				filtered = append(filtered, e)
+			}
+		}
+		if len(filtered) == 0 {
+			fmt.Printf("No history for service: %s\n", serviceName)
+			os.Exit(1)
+		}
+		entries = filtered
+	}
+
+	histories := summarizeHistory(entries, since, now)
+
+	if *jsonFlag {
+		data, _ := json.MarshalIndent(struct {
+			Since    time.Time        `json:"since"`
+			Until    time.Time        `json:"until"`
+			Services []ServiceHistory `json:"services"`
+		}{since, now, histories}, "", "  ")
+		fmt.Println(string(data))
+		return
+	}
+	fmt.Print(FormatHistory(histories, since))
+}
+
+func handleSchema(name string) {
+	schema, ok := jsonSchemas[name]
+	if !ok {
+		fmt.Printf("Unknown schema: %s (available: state)\n", name)
+		os.Exit(1)
+	}
+	fmt.Print(schema)
+}
+
 func handleCompletion(action string) {
 	home := getUserHomeDir()
 	zshCompletionPath := filepath.Join(home, ".zsh/completions/_gatekeeper")
@@ -311,11 +942,12 @@ func generateZshCompletion() string {
 	home := getUserHomeDir()
 	configFile := filepath.Join(home, ".config/gatekeeper/config.yaml")
 
-	var serviceNames []string
+	var serviceNames, groupNames []string
 	if config, err := loadConfig(configFile); err == nil {
 		for _, svc := range config.Services {
 			serviceNames = append(serviceNames, svc.Name)
 		}
+		groupNames = configGroups(config)
 	}
 
 	servicesStr := ""
@@ -324,6 +956,9 @@ func generateZshCompletion() string {
 			servicesStr += fmt.Sprintf("    '%s:Auth for %s'\n", name, name)
 		}
 	}
+	for _, group := range groupNames {
+		servicesStr += fmt.Sprintf("    '@%s:Auth for group %s'\n", group, group)
+	}
 
 	return `#compdef gatekeeper
 
@@ -332,9 +967,16 @@ _gatekeeper() {
   commands=(
     'start:Start the daemon'
     'stop:Stop the daemon'
+    'reload:Reload daemon config'
     'status:Show service status'
+    'check:Check services now without the daemon'
+    'run:Run a command once services are authenticated'
+    'wait:Wait until services are authenticated'
     'auth:Authenticate a service'
     'init:Initialize config file'
+    'validate:Validate config file'
+    'history:Show check history and uptime'
+    'schema:Print JSON schema for status --json'
     'completion:Manage shell completions'
   )
 
@@ -342,6 +984,42 @@ _gatekeeper() {
   status_flags=(
     '--json:Output as JSON'
     '--compact:Compact output for tmux'
+    '--verbose:Show exit codes, durations and command output'
+    '--group:Only show services in this group'
+    '--collapse:With --compact, show each group as one aggregate'
+  )
+
+  local -a check_flags
+  check_flags=(
+    '--json:Output as JSON'
+    '--compact:Compact output for tmux'
+    '--verbose:Show exit codes, durations and command output'
+    '--fail-fast:Stop at the first service that is not ok'
+    '--update-state:Save the results to state.json'
+    '--config:Path to config file'
+  )
+
+  local -a run_flags
+  run_flags=(
+    '--require:Comma-separated services that must be authenticated'
+    '--max-age:Trust cached results up to this old'
+    '--yes:Run auth_cmd without asking'
+    '--no-auth:Fail instead of offering to authenticate'
+    '--config:Path to config file'
+  )
+
+  local -a wait_flags
+  wait_flags=(
+    '--timeout:Give up after this long'
+    '--state:State to wait for'
+    '--interval:How often to re-check'
+    '--config:Path to config file'
+  )
+
+  local -a history_flags
+  history_flags=(
+    '--since:Show history since a duration ago or a date'
+    '--json:Output as JSON'
   )
 
   local -a auth_services
@@ -366,6 +1044,21 @@ _gatekeeper() {
       auth)
         _describe 'service' auth_services
         ;;
+      check)
+        _describe 'flag' check_flags
+        ;;
+      run)
+        _describe 'flag' run_flags
+        ;;
+      wait)
+        _describe 'flag' wait_flags
+        ;;
+      history)
+        _describe 'flag' history_flags
+        ;;
+      schema)
+        _values 'schema' state
+        ;;
       completion)
         _describe 'action' completion_actions
         ;;
@@ -384,12 +1077,20 @@ func printUsage() {
 	fmt.Println(`
 
 Usage:
-  gatekeeper start [--config path]                    Start daemon (auto-uses ~/.config/gatekeeper/config.yaml)
+  gatekeeper start [--config path] [--lenient] [--textfile]  Start daemon (auto-uses ~/.config/gatekeeper/config.yaml)
   gatekeeper stop                                      Stop daemon
-  gatekeeper status [--json|--compact]                 Show current status
-  gatekeeper auth <service-name|all>                   Run auth command for service(s)
+  gatekeeper reload                                    Reload daemon config (same as SIGHUP)
+  gatekeeper status [--json|--compact|--verbose]       Show current status
+                    [--group name] [--collapse]        Only one group; collapse groups in --compact
+  gatekeeper auth [--exact] <service-name|all>         Run auth command for service(s)
+  gatekeeper check [service...] [--json] [--fail-fast] Check now without the daemon (exit 0 ok, 1 unauthenticated, 2 error)
+  gatekeeper run [--require a,b] [--max-age 5m] -- cmd Run cmd once the services are authenticated (exit 125 if not)
+  gatekeeper wait <service...> [--timeout 5m] [--state ok] Block until the services reach a state
   gatekeeper completion <install|uninstall>            Manage zsh completions
   gatekeeper init                                      Initialize config file
+  gatekeeper validate [path]                           Check config file for errors
+  gatekeeper history [service] [--since 24h] [--json] Show state changes and uptime
+  gatekeeper schema state                              Print JSON schema of state.json / status --json
 
 Examples:
   gatekeeper start                                     # Uses default config
@@ -397,8 +1098,15 @@ Examples:
   gatekeeper stop
   gatekeeper status --compact
   gatekeeper status --json
+  gatekeeper status --verbose                          # Why a check failed
   gatekeeper auth github                               # Auth GitHub (case-insensitive)
   gatekeeper auth aws                                  # Auth all AWS services
+  gatekeeper auth @prod                                # Auth every service in group prod
+  gatekeeper check tag:aws                             # Check services tagged aws
   gatekeeper auth all                                  # Auth all services
+  gatekeeper validate                                  # Validate default config
+  gatekeeper check --fail-fast || exit 1               # Gate a CI job on valid credentials
+  gatekeeper run --require aws-prod -- terraform apply # Log in first if the session expired
+  gatekeeper history GitHub --since 7d                 # When did GitHub auth break?
   gatekeeper completion install                        # Install zsh completions`)
 }
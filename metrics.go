@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// checkDurationBuckets are the upper bounds of the check duration histogram, in seconds
+var checkDurationBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+func getTextfilePath() string {
+	return filepath.Join(filepath.Dir(getStatePath()), "gatekeeper.prom")
+}
+
+// serviceMetrics accumulates metrics for one service
+type serviceMetrics struct {
+	status    ServiceStatus
+	checks    map[CheckState]int64
+	retries   int64
+	buckets   []int64 // cumulative counts per checkDurationBuckets
+	count     int64
+	sumSecond float64
+}
+
+// metricsCollector turns check results into Prometheus metrics
+type metricsCollector struct {
+	mu        sync.Mutex
+	startedAt time.Time
+	order     []string // services in config order
+	services  map[string]*serviceMetrics
+}
+
+func newMetricsCollector(startedAt time.Time) *metricsCollector {
+	return &metricsCollector{startedAt: startedAt, services: make(map[string]*serviceMetrics)}
+}
+
+// Configure follows the service list of a (possibly reloaded) config.
+// Metrics of removed services are dropped.
+func (m *metricsCollector) Configure(config *Config) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.order = m.order[:0]
+	keep := make(map[string]*serviceMetrics)
+	for _, svc := range config.Services {
+		m.order = append(m.order, svc.Name)
+		if sm, ok := m.services[svc.Name]; ok {
+			keep[svc.Name] = sm
+		}
+	}
+	m.services = keep
+}
+
+// Observe records fresh check results
+func (m *metricsCollector) Observe(statuses []ServiceStatus) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, st := range statuses {
+		sm, ok := m.services[st.Name]
+		if !ok {
+			sm = &serviceMetrics{
+				checks:  make(map[CheckState]int64),
+				buckets: make([]int64, len(checkDurationBuckets)),
+			}
+			m.services[st.Name] = sm
+		}
+
+		sm.status = st
+		state := statusState(st)
+		// Disabled and blocked services, and those in a dependency cycle,
+		// weren't checked, so they don't count as checks
+		if state == StateDisabled || state == StateBlocked || st.Attempt == 0 {
+			continue
+		}
+
+		sm.checks[state]++
+		if st.Attempt > 1 {
+			sm.retries += int64(st.Attempt - 1)
+		}
+		seconds := float64(st.DurationMs) / 1000
+		for i, le := range checkDurationBuckets {
+			if seconds <= le {
+				sm.buckets[i]++
+			}
+		}
+		sm.count++
+		sm.sumSecond += seconds
+	}
+}
+
+// WriteTo writes all metrics in the Prometheus text format
+func (m *metricsCollector) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var b strings.Builder
+	now := time.Now()
+
+	metric := func(name, kind, help string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+	each := func(fn func(name string, sm *serviceMetrics)) {
+		for _, name := range m.order {
+			if sm, ok := m.services[name]; ok {
+				fn(name, sm)
+			}
+		}
+	}
+
+	metric("gatekeeper_build_info", "gauge", "Version of the running gatekeeper.")
+	fmt.Fprintf(&b, "gatekeeper_build_info{version=%s} 1\n", quoteLabel(Version))
+
+	metric("gatekeeper_daemon_start_time_seconds", "gauge", "Unix time the daemon started.")
+	fmt.Fprintf(&b, "gatekeeper_daemon_start_time_seconds %d\n", m.startedAt.Unix())
+	metric("gatekeeper_daemon_uptime_seconds", "gauge", "Seconds since the daemon started.")
+	fmt.Fprintf(&b, "gatekeeper_daemon_uptime_seconds %s\n", formatFloat(now.Sub(m.startedAt).Seconds()))
+
+	metric("gatekeeper_service_up", "gauge", "Whether the last check of the service passed.")
+	each(func(name string, sm *serviceMetrics) {
+		up := 0
+		if statusState(sm.status) == StateOK {
+			up = 1
+		}
+		fmt.Fprintf(&b, "gatekeeper_service_up{service=%s} %d\n", quoteLabel(name), up)
+	})
+
+	metric("gatekeeper_service_state", "gauge", "Current state of the service (1 for the current state).")
+	each(func(name string, sm *serviceMetrics) {
+		current := statusState(sm.status)
+		for _, state := range checkStates {
+			v := 0
+			if state == current {
+				v = 1
+			}
+			fmt.Fprintf(&b, "gatekeeper_service_state{service=%s,state=%s} %d\n", quoteLabel(name), quoteLabel(string(state)), v)
+		}
+	})
+
+	metric("gatekeeper_service_last_check_timestamp_seconds", "gauge", "Unix time of the last check.")
+	each(func(name string, sm *serviceMetrics) {
+		if !sm.status.LastChecked.IsZero() {
+			fmt.Fprintf(&b, "gatekeeper_service_last_check_timestamp_seconds{service=%s} %d\n", quoteLabel(name), sm.status.LastChecked.Unix())
+		}
+	})
+
+	metric("gatekeeper_service_expiry_seconds", "gauge", "Seconds until the service's credentials expire; negative once expired.")
+	each(func(name string, sm *serviceMetrics) {
+		if sm.status.ExpiresAt != nil {
+			fmt.Fprintf(&b, "gatekeeper_service_expiry_seconds{service=%s} %s\n", quoteLabel(name), formatFloat(sm.status.ExpiresAt.Sub(now).Seconds()))
+		}
+	})
+
+	metric("gatekeeper_checks_total", "counter", "Checks run, by resulting state.")
+	each(func(name string, sm *serviceMetrics) {
+		for _, state := range checkStates {
+			if n, ok := sm.checks[state]; ok {
+				fmt.Fprintf(&b, "gatekeeper_checks_total{service=%s,state=%s} %d\n", quoteLabel(name), quoteLabel(string(state)), n)
+			}
+		}
+	})
+
+	metric("gatekeeper_check_retries_total", "counter", "Retries of checks that failed on an earlier attempt.")
+	each(func(name string, sm *serviceMetrics) {
+		fmt.Fprintf(&b, "gatekeeper_check_retries_total{service=%s} %d\n", quoteLabel(name), sm.retries)
+	})
+
+	metric("gatekeeper_check_duration_seconds", "histogram", "Time spent on a check, including retries.")
+	each(func(name string, sm *serviceMetrics) {
+		label := quoteLabel(name)
+		for i, le := range checkDurationBuckets {
+			fmt.Fprintf(&b, "gatekeeper_check_duration_seconds_bucket{service=%s,le=\"%s\"} %d\n", label, formatFloat(le), sm.buckets[i])
+		}
+		fmt.Fprintf(&b, "gatekeeper_check_duration_seconds_bucket{service=%s,le=\"+Inf\"} %d\n", label, sm.count)
+		fmt.Fprintf(&b, "gatekeeper_check_duration_seconds_sum{service=%s} %s\n", label, formatFloat(sm.sumSecond))
+		fmt.Fprintf(&b, "gatekeeper_check_duration_seconds_count{service=%s} %d\n", label, sm.count)
+	})
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// WriteTextfile writes the metrics for node_exporter's textfile collector
+func (m *metricsCollector) WriteTextfile(path string) error {
+	var b strings.Builder
+	m.WriteTo(&b)
+	return writeFileAtomic(path, []byte(b.String()), 0644)
+}
+
+// quoteLabel quotes a label value as the text format requires
+func quoteLabel(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	return `"` + r.Replace(s) + `"`
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// metricsServer serves /metrics on the configured address
+type metricsServer struct {
+	collector *metricsCollector
+	logger    *Logger
+	addr      string
+	server    *http.Server
+}
+
+func newMetricsServer(collector *metricsCollector, logger *Logger) *metricsServer {
+	return &metricsServer{collector: collector, logger: logger}
+}
+
+// Configure starts, stops or moves the server to match addr ("" disables it)
+func (s *metricsServer) Configure(addr string) {
+	if addr == s.addr {
+		return
+	}
+	s.Close()
+	if addr == "" {
+		return
+	}
+
+	// addr is only recorded once listening works, so a reload retries
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		s.logger.Errorf("Metrics disabled: %v", err)
+		return
+	}
+	s.addr = addr
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		s.collector.WriteTo(w)
+	})
+	s.server = &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	s.logger.Infof("Serving metrics on http://%s/metrics", listener.Addr())
+	go func(server *http.Server) {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.logger.Errorf("Metrics server stopped: %v", err)
+		}
+	}(s.server)
+}
+
+func (s *metricsServer) Close() {
+	s.addr = ""
+	if s.server == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	s.server.Shutdown(ctx)
+	s.server = nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsSkipUncheckedServices(t *testing.T) {
+	m := newMetricsCollector(time.Now())
+	m.Configure(&Config{Services: []Service{{Name: "VPN"}, {Name: "Vault"}, {Name: "A"}}})
+
+	vpn := ServiceStatus{Name: "VPN", State: StateUnauthenticated, Attempt: 2, DurationMs: 300}
+	m.Observe([]ServiceStatus{
+		vpn,
+		blockedStatus(Service{Name: "Vault"}, vpn),
+		{Name: "A", State: StateError, Error: "dependency cycle: A -> B -> A"},
+	})
+
+	var b strings.Builder
+	m.WriteTo(&b)
+	out := b.String()
+
+	for _, want := range []string{
+		`gatekeeper_checks_total{service="VPN",state="unauthenticated"} 1`,
+		`gatekeeper_check_retries_total{service="VPN"} 1`,
+		`gatekeeper_check_duration_seconds_count{service="VPN"} 1`,
+	} {
+		if !strings.Contains(out, want+"\n") {
+			t.Errorf("missing %s", want)
+		}
+	}
+	for _, service := range []string{"Vault", "A"} {
+		for _, metric := range []string{"gatekeeper_checks_total", "gatekeeper_check_duration_seconds_count"} {
+			prefix := metric + `{service="` + service + `"`
+			for _, line := range strings.Split(out, "\n") {
+				if strings.HasPrefix(line, prefix) && !strings.HasSuffix(line, " 0") {
+					t.Errorf("unchecked service counted: %s", line)
+				}
+			}
+		}
+	}
+	if !strings.Contains(out, `gatekeeper_service_state{service="Vault",state="blocked"} 1`) {
+		t.Error("blocked service should still report its state")
+	}
+}
+
+func TestMetricsServerRetriesListen(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	s := newMetricsServer(newMetricsCollector(time.Now()), NewLogger(LogDebug))
+	defer s.Close()
+
+	// Take the port so the first listen fails
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := busy.Addr().String()
+	s.Configure(addr)
+	if s.server != nil {
+		t.Fatal("server started on a busy port")
+	}
+
+	// A reload with the same address tries again
+	busy.Close()
+	s.Configure(addr)
+	resp, err := http.Get("http://" + addr + "/metrics")
+	if err != nil {
+		t.Fatalf("metrics not served after retry: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if !strings.Contains(string(body), "gatekeeper_build_info") {
+		t.Errorf("unexpected metrics:\n%s", body)
+	}
+}
@@ -0,0 +1,470 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Notifier types
+const (
+	NotifierWebhook = "webhook" // POST the alert as JSON, or a templated body
+	NotifierSlack   = "slack"   // Slack incoming webhook
+	NotifierSMTP    = "smtp"    // email digest
+)
+
+const (
+	DefaultNotifierTimeout     = 10 * time.Second
+	DefaultNotifierMaxAttempts = 10
+)
+
+// Delivery retries back off from 10s up to 10 minutes
+var outboxRetry = backoff{
+	Initial:    10 * time.Second,
+	Multiplier: 2,
+	Max:        10 * time.Minute,
+	Jitter:     0.1,
+}
+
+func getOutboxDir() string {
+	home := getUserHomeDir()
+	return filepath.Join(home, ".cache", "gatekeeper", "outbox")
+}
+
+// wantsAlert reports whether a notifier is interested in an alert
+func (n NotifierConfig) wantsAlert(a Alert) bool {
+	minSeverity := n.MinSeverity
+	if minSeverity == "" {
+		minSeverity = SeverityWarning
+	}
+	if severityRank(a.Severity) < severityRank(minSeverity) {
+		return false
+	}
+	return len(n.Events) == 0 || slices.Contains(n.Events, a.Event)
+}
+
+// templateFuncs are available in notifier templates
+var templateFuncs = template.FuncMap{
+	// json encodes a value, e.g. {"text": {{json .Error}}}
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+func parseNotifierTemplate(text string) (*template.Template, error) {
+	return template.New("notifier").Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+}
+
+// renderAlert builds the request body for a notifier
+func renderAlert(n NotifierConfig, a Alert) ([]byte, error) {
+	if n.Template != "" {
+		tmpl, err := parseNotifierTemplate(n.Template)
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, a); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	if n.Type == NotifierSlack {
+		return json.Marshal(slackMessage(a))
+	}
+	return json.Marshal(a)
+}
+
+// slackMessage formats an alert for a Slack incoming webhook
+func slackMessage(a Alert) map[string]interface{} {
+	emoji, color := ":warning:", "warning"
+	switch a.Event {
+	case AlertFail:
+		emoji, color = ":red_circle:", "danger"
+	case AlertRecover:
+		emoji, color = ":large_green_circle:", "good"
+	}
+
+	text := fmt.Sprintf("%s *%s*", emoji, a.Summary())
+	if a.Host != "" {
+		text += " on `" + a.Host + "`"
+	}
+
+	attachment := map[string]interface{}{
+		"color": color,
+		"fields": []map[string]interface{}{
+			{"title": "Severity", "value": a.Severity, "short": true},
+			{"title": "State", "value": string(a.State), "short": true},
+		},
+		"ts": a.Time.Unix(),
+	}
+	if a.Error != "" {
+		attachment["text"] = a.Error
+	}
+
+	return map[string]interface{}{
+		"text":        text,
+		"attachments": []interface{}{attachment},
+	}
+}
+
+// outboxEntry is a pending delivery, stored as one file in the outbox
+type outboxEntry struct {
+	Notifier    string    `json:"notifier"`
+	Alert       Alert     `json:"alert"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next_attempt"`
+	LastError   string    `json:"last_error,omitempty"`
+
+	path string
+}
+
+// permanentError is a delivery failure that retrying won't fix
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+
+// alertOutbox delivers alerts to HTTP notifiers. Alerts are written to
+// the outbox directory before the first attempt and removed once
+// delivered, so pending ones are retried after a daemon restart.
+type alertOutbox struct {
+	dir    string
+	client *http.Client
+	logger *Logger
+	retry  backoff
+
+	mu         sync.Mutex
+	notifiers  map[string]NotifierConfig
+	severities map[string]string    // service -> severity, for digests
+	lastSent   map[string]time.Time // email notifier -> last digest
+	warned     expiryWarnings       // expiry warnings already queued
+	wake       chan struct{}
+	seq        int
+}
+
+func newAlertOutbox(dir string, logger *Logger) *alertOutbox {
+	o := &alertOutbox{
+		dir:        dir,
+		client:     &http.Client{},
+		logger:     logger,
+		retry:      outboxRetry,
+		notifiers:  make(map[string]NotifierConfig),
+		severities: make(map[string]string),
+		lastSent:   make(map[string]time.Time),
+		warned:     make(expiryWarnings),
+		wake:       make(chan struct{}, 1),
+	}
+	o.loadLastSent()
+	return o
+}
+
+// Configure applies the notifiers and service severities of a (possibly reloaded) config
+func (o *alertOutbox) Configure(config *Config) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.notifiers = make(map[string]NotifierConfig)
+	for _, n := range config.Notifiers {
+		o.notifiers[n.Name] = n
+	}
+	o.severities = make(map[string]string)
+	for _, svc := range config.Services {
+		o.severities[svc.Name] = serviceSeverity(svc)
+	}
+	o.notify()
+}
+
+// Enqueue stores an entry for every notifier that wants each alert
+func (o *alertOutbox) Enqueue(alerts []Alert) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if len(o.notifiers) == 0 {
+		return
+	}
+	if err := os.MkdirAll(o.dir, 0700); err != nil {
+		o.logger.Errorf("Error creating outbox: %v", err)
+		return
+	}
+
+	// Sorted for a predictable delivery order
+	names := make([]string, 0, len(o.notifiers))
+	for name := range o.notifiers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, a := range alerts {
+		if !o.warned.due(a) {
+			continue
+		}
+		queued := true
+		for _, name := range names {
+			if !o.notifiers[name].wantsAlert(a) {
+				continue
+			}
+			o.seq++
+			entry := &outboxEntry{
+				Notifier:    name,
+				Alert:       a,
+				NextAttempt: a.Time,
+				path:        filepath.Join(o.dir, fmt.Sprintf("%d-%d.json", a.Time.UnixNano(), o.seq)),
+			}
+			if err := o.save(entry); err != nil {
+				o.logger.Errorf("[%s] Error queueing alert for %s: %v", a.Service, name, err)
+				queued = false
+			}
+		}
+		if queued {
+			o.warned.delivered(a)
+		}
+	}
+	o.notify()
+}
+
+func (o *alertOutbox) notify() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run delivers pending alerts until ctx is done
+func (o *alertOutbox) Run(ctx context.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		next := o.deliverDue(ctx)
+		if next.IsZero() {
+			timer.Reset(time.Hour)
+		} else {
+			timer.Reset(time.Until(next))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		case <-o.wake:
+		}
+	}
+}
+
+// deliverDue attempts every entry that is due and returns when the
+// next one will be, or the zero time if the outbox is empty
+func (o *alertOutbox) deliverDue(ctx context.Context) time.Time {
+	entries, err := o.load()
+	if err != nil {
+		o.logger.Errorf("Error reading outbox: %v", err)
+		return time.Now().Add(time.Minute)
+	}
+
+	var next time.Time
+	later := func(t time.Time) {
+		if next.IsZero() || t.Before(next) {
+			next = t
+		}
+	}
+
+	// Webhooks get one request per alert; email notifiers send all
+	// their due alerts as one digest
+	var batches [][]*outboxEntry
+	digests := make(map[string]int) // notifier -> index in batches
+	for _, entry := range entries {
+		if entry.NextAttempt.After(time.Now()) {
+			later(entry.NextAttempt)
+			continue
+		}
+
+		o.mu.Lock()
+		notifier, ok := o.notifiers[entry.Notifier]
+		o.mu.Unlock()
+		if !ok {
+			o.logger.Warnf("[%s] Dropping alert for removed notifier %s", entry.Alert.Service, entry.Notifier)
+			os.Remove(entry.path)
+			continue
+		}
+
+		if notifier.Type != NotifierSMTP {
+			batches = append(batches, []*outboxEntry{entry})
+			continue
+		}
+		if quietUntil := o.quietUntil(notifier); quietUntil.After(time.Now()) {
+			later(quietUntil)
+			continue
+		}
+		if i, ok := digests[entry.Notifier]; ok {
+			batches[i] = append(batches[i], entry)
+		} else {
+			digests[entry.Notifier] = len(batches)
+			batches = append(batches, []*outboxEntry{entry})
+		}
+	}
+
+	for _, batch := range batches {
+		if ctx.Err() != nil {
+			return time.Time{}
+		}
+		if t := o.deliverBatch(ctx, batch); !t.IsZero() {
+			later(t)
+		}
+	}
+	return next
+}
+
+// deliverBatch sends a batch of entries for one notifier and removes them,
+// or schedules a retry. Returns when the retry is due, if there is one.
+func (o *alertOutbox) deliverBatch(ctx context.Context, batch []*outboxEntry) time.Time {
+	name := batch[0].Notifier
+	o.mu.Lock()
+	notifier := o.notifiers[name]
+	o.mu.Unlock()
+
+	alerts := make([]Alert, len(batch))
+	attempts := 0
+	for i, entry := range batch {
+		entry.Attempts++
+		alerts[i] = entry.Alert
+		attempts = max(attempts, entry.Attempts)
+	}
+
+	var err error
+	if notifier.Type == NotifierSMTP {
+		err = o.sendDigest(ctx, notifier, alerts)
+	} else {
+		err = o.deliver(ctx, notifier, alerts[0])
+	}
+
+	what := fmt.Sprintf("[%s] %s alert", alerts[0].Service, alerts[0].Event)
+	if len(alerts) > 1 {
+		what = fmt.Sprintf("Digest of %d alerts", len(alerts))
+	}
+
+	if err == nil {
+		o.logger.Infof("%s sent to %s", what, name)
+		for _, entry := range batch {
+			os.Remove(entry.path)
+		}
+		return time.Time{}
+	}
+	if ctx.Err() != nil {
+		return time.Time{}
+	}
+
+	maxAttempts := DefaultNotifierMaxAttempts
+	if notifier.MaxAttempts > 0 {
+		maxAttempts = notifier.MaxAttempts
+	}
+	var permanent *permanentError
+	if errors.As(err, &permanent) || attempts >= maxAttempts {
+		o.logger.Errorf("%s for %s dropped after %d attempts: %v", what, name, attempts, err)
+		for _, entry := range batch {
+			os.Remove(entry.path)
+		}
+		return time.Time{}
+	}
+
+	delay := o.retry.Delay(attempts)
+	o.logger.Warnf("%s for %s failed (attempt %d/%d), retrying in %s: %v",
+		what, name, attempts, maxAttempts, delay.Round(time.Second), err)
+	retryAt := time.Now().Add(delay)
+	for _, entry := range batch {
+		entry.NextAttempt = retryAt
+		entry.LastError = err.Error()
+		if err := o.save(entry); err != nil {
+			o.logger.Errorf("Error updating outbox: %v", err)
+		}
+	}
+	return retryAt
+}
+
+// deliver sends one alert. 4xx responses other than 408 and 429 are permanent.
+func (o *alertOutbox) deliver(ctx context.Context, notifier NotifierConfig, a Alert) error {
+	body, err := renderAlert(notifier, a)
+	if err != nil {
+		return &permanentError{fmt.Errorf("rendering template: %w", err)}
+	}
+
+	timeout := DefaultNotifierTimeout
+	if notifier.Timeout > 0 {
+		timeout = time.Duration(notifier.Timeout) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, os.ExpandEnv(notifier.URL), bytes.NewReader(body))
+	if err != nil {
+		return &permanentError{err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "gatekeeper/"+Version)
+	for k, v := range notifier.Headers {
+		req.Header.Set(k, os.ExpandEnv(v))
+	}
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	err = fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 &&
+		resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests {
+		return &permanentError{err}
+	}
+	return err
+}
+
+// load reads the outbox, oldest first. Unreadable files are removed.
+func (o *alertOutbox) load() ([]*outboxEntry, error) {
+	files, err := filepath.Glob(filepath.Join(o.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	var entries []*outboxEntry
+	for _, path := range files {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		entry := &outboxEntry{path: path}
+		if err := json.Unmarshal(data, entry); err != nil {
+			o.logger.Warnf("Removing corrupt outbox entry %s: %v", filepath.Base(path), err)
+			os.Remove(path)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (o *alertOutbox) save(entry *outboxEntry) error {
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(entry.path, data, 0600)
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookServer answers with the queued status codes, then 200, and
+// records the alerts it received
+type webhookServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	statuses []int
+	received []Alert
+	headers  []http.Header
+}
+
+func newWebhookServer(t *testing.T, statuses ...int) *webhookServer {
+	s := &webhookServer{statuses: statuses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if len(s.statuses) > 0 {
+			status := s.statuses[0]
+			s.statuses = s.statuses[1:]
+			if status != http.StatusOK {
+				http.Error(w, "try later", status)
+				return
+			}
+		}
+		body, _ := io.ReadAll(r.Body)
+		var a Alert
+		if err := json.Unmarshal(body, &a); err != nil {
+			t.Errorf("webhook body is not an alert: %v: %s", err, body)
+		}
+		s.received = append(s.received, a)
+		s.headers = append(s.headers, r.Header.Clone())
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *webhookServer) Received() []Alert {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Alert(nil), s.received...)
+}
+
+// newTestOutbox returns an outbox in dir that retries after a millisecond
+func newTestOutbox(t *testing.T, dir string, notifiers ...NotifierConfig) *alertOutbox {
+	t.Helper()
+	o := newAlertOutbox(dir, NewLogger(LogDebug))
+	o.retry = backoff{Initial: time.Millisecond, Multiplier: 1, Max: time.Millisecond}
+	o.Configure(&Config{Notifiers: notifiers})
+	return o
+}
+
+func testAlert(service string) Alert {
+	return Alert{
+		ID:       service + "-1",
+		Event:    AlertFail,
+		Service:  service,
+		Severity: SeverityCritical,
+		State:    StateUnauthenticated,
+		Error:    "exit code 1",
+		Time:     time.Now(),
+	}
+}
+
+func pendingEntries(t *testing.T, o *alertOutbox) []*outboxEntry {
+	t.Helper()
+	entries, err := o.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+func TestOutboxDelivers(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("WEBHOOK_TOKEN", "secret")
+	srv := newWebhookServer(t)
+	o := newTestOutbox(t, filepath.Join(t.TempDir(), "outbox"), NotifierConfig{
+		Name:    "hook",
+		Type:    NotifierWebhook,
+		URL:     srv.URL,
+		Headers: map[string]string{"Authorization": "Bearer $WEBHOOK_TOKEN"},
+	})
+
+	o.Enqueue([]Alert{testAlert("GitHub")})
+	if next := o.deliverDue(context.Background()); !next.IsZero() {
+		t.Errorf("got a retry at %s after a successful delivery", next)
+	}
+
+	received := srv.Received()
+	if len(received) != 1 || received[0].Service != "GitHub" || received[0].Event != AlertFail {
+		t.Fatalf("webhook received %+v", received)
+	}
+	if got := srv.headers[0].Get("Authorization"); got != "Bearer secret" {
+		t.Errorf("got Authorization %q, want the expanded header", got)
+	}
+	if entries := pendingEntries(t, o); len(entries) != 0 {
+		t.Errorf("%d entries left in the outbox after delivery", len(entries))
+	}
+}
+
+func TestOutboxRetriesServerErrors(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	srv := newWebhookServer(t, http.StatusServiceUnavailable, http.StatusTooManyRequests)
+	o := newTestOutbox(t, filepath.Join(t.TempDir(), "outbox"), NotifierConfig{Name: "hook", Type: NotifierWebhook, URL: srv.URL})
+
+	o.Enqueue([]Alert{testAlert("Vault")})
+	for attempt := 1; attempt <= 2; attempt++ {
+		next := o.deliverDue(context.Background())
+		if next.IsZero() {
+			t.Fatalf("attempt %d: no retry scheduled", attempt)
+		}
+		entries := pendingEntries(t, o)
+		if len(entries) != 1 || entries[0].Attempts != attempt || entries[0].LastError == "" {
+			t.Fatalf("attempt %d: outbox has %+v", attempt, entries)
+		}
+		time.Sleep(time.Until(next))
+	}
+
+	o.deliverDue(context.Background())
+	if got := len(srv.Received()); got != 1 {
+		t.Errorf("got %d deliveries, want 1", got)
+	}
+	if entries := pendingEntries(t, o); len(entries) != 0 {
+		t.Errorf("%d entries left in the outbox after delivery", len(entries))
+	}
+}
+
+func TestOutboxDropsFailedDeliveries(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	t.Run("permanent error", func(t *testing.T) {
+		srv := newWebhookServer(t, http.StatusBadRequest)
+		o := newTestOutbox(t, filepath.Join(t.TempDir(), "outbox"), NotifierConfig{Name: "hook", Type: NotifierWebhook, URL: srv.URL})
+		o.Enqueue([]Alert{testAlert("AWS")})
+
+		if next := o.deliverDue(context.Background()); !next.IsZero() {
+			t.Errorf("a 400 was scheduled for retry at %s", next)
+		}
+		if entries := pendingEntries(t, o); len(entries) != 0 {
+			t.Errorf("a 400 left %d entries in the outbox", len(entries))
+		}
+	})
+
+	t.Run("max attempts", func(t *testing.T) {
+		srv := newWebhookServer(t, http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway)
+		o := newTestOutbox(t, filepath.Join(t.TempDir(), "outbox"), NotifierConfig{Name: "hook", Type: NotifierWebhook, URL: srv.URL, MaxAttempts: 2})
+		o.Enqueue([]Alert{testAlert("AWS")})
+
+		next := o.deliverDue(context.Background())
+		time.Sleep(time.Until(next))
+		if next := o.deliverDue(context.Background()); !next.IsZero() {
+			t.Errorf("retry scheduled after max_attempts")
+		}
+		if entries := pendingEntries(t, o); len(entries) != 0 {
+			t.Errorf("%d entries left after max_attempts", len(entries))
+		}
+		if got := len(srv.Received()); got != 0 {
+			t.Errorf("got %d deliveries, want 0", got)
+		}
+	})
+}
+
+func TestOutboxSurvivesRestart(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	dir := filepath.Join(t.TempDir(), "outbox")
+	srv := newWebhookServer(t, http.StatusServiceUnavailable)
+	notifier := NotifierConfig{Name: "hook", Type: NotifierWebhook, URL: srv.URL}
+
+	// The first daemon queues an alert and fails to deliver it
+	before := newTestOutbox(t, dir, notifier)
+	before.Enqueue([]Alert{testAlert("Okta")})
+	next := before.deliverDue(context.Background())
+	if next.IsZero() {
+		t.Fatal("no retry scheduled")
+	}
+
+	// After a restart the pending alert is still there and gets delivered
+	after := newTestOutbox(t, dir, notifier)
+	entries := pendingEntries(t, after)
+	if len(entries) != 1 || entries[0].Alert.Service != "Okta" || entries[0].Attempts != 1 {
+		t.Fatalf("outbox after restart has %+v", entries)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go after.Run(ctx)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(srv.Received()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	received := srv.Received()
+	if len(received) != 1 || received[0].Service != "Okta" {
+		t.Fatalf("webhook received %+v after restart", received)
+	}
+}
+
+func TestOutboxQueuesExpiryWarningOnce(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	srv := newWebhookServer(t)
+	notifier := NotifierConfig{Name: "hook", Type: NotifierWebhook, URL: srv.URL}
+	o := newTestOutbox(t, filepath.Join(t.TempDir(), "outbox"), notifier)
+
+	config := &Config{Services: []Service{{Name: "AWS"}}, Notifiers: []NotifierConfig{notifier}}
+	detector := newAlertDetector()
+	expires := time.Now().Add(10 * time.Minute)
+	expiring := []ServiceStatus{{Name: "AWS", State: StateOK, IsAlive: true, ExpiresAt: &expires}}
+
+	// Detect repeats the warning on every check in the window
+	for range 3 {
+		o.Enqueue(detector.Detect(config, nil, expiring))
+		o.deliverDue(context.Background())
+	}
+	// A new window after a login is warned about again
+	o.Enqueue(detector.Detect(config, nil, []ServiceStatus{{Name: "AWS", State: StateOK, IsAlive: true}}))
+	o.Enqueue(detector.Detect(config, nil, expiring))
+	o.deliverDue(context.Background())
+
+	received := srv.Received()
+	if len(received) != 2 || received[0].ID == received[1].ID {
+		t.Fatalf("webhook received %+v, want one warning per window", received)
+	}
+	for _, a := range received {
+		if a.Event != AlertExpiring {
+			t.Errorf("got %s alert, want expiring", a.Event)
+		}
+	}
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultNotifyRateLimit is the minimum time between notifications of one
+// kind for a service
+const DefaultNotifyRateLimit = 5 * time.Minute
+
+// Urgency levels defined by the freedesktop notification spec
+type Urgency byte
+
+const (
+	UrgencyLow      Urgency = 0
+	UrgencyNormal   Urgency = 1
+	UrgencyCritical Urgency = 2
+)
+
+// Notification is a desktop notification about one service
+type Notification struct {
+	Service string
+	Event   string // alert event, which rate limits are kept per
+	Summary string
+	Body    string
+	Urgency Urgency
+	Action  string // label of a button that re-authenticates the service; empty for none
+}
+
+// Notifier shows desktop notifications. The D-Bus implementation is used
+// by the daemon; tests and other platforms can plug in their own.
+type Notifier interface {
+	// Notify shows n, replacing an earlier notification for the same service
+	Notify(n Notification) error
+	// Listen calls onAction with the service whose action button was
+	// clicked, until ctx is done
+	Listen(ctx context.Context, onAction func(service string)) error
+}
+
+// notificationManager decides what to notify about and rate limits it
+type notificationManager struct {
+	ctx      context.Context
+	notifier Notifier
+	logger   *Logger
+	now      func() time.Time
+
+	mu       sync.Mutex // guards config, which action clicks read
+	config   NotificationsConfig
+	lastSent map[string]time.Time // per service and event
+	warned   expiryWarnings
+	listen   sync.Once
+}
+
+func newNotificationManager(ctx context.Context, notifier Notifier, logger *Logger) *notificationManager {
+	return &notificationManager{
+		ctx:      ctx,
+		notifier: notifier,
+		logger:   logger,
+		now:      time.Now,
+		lastSent: make(map[string]time.Time),
+		warned:   make(expiryWarnings),
+	}
+}
+
+// Update shows desktop notifications for failures and upcoming expiry,
+// and for recoveries if enabled
+func (m *notificationManager) Update(config *Config, alerts []Alert) {
+	m.mu.Lock()
+	m.config = config.Notifications
+	m.mu.Unlock()
+	if !config.Notifications.Desktop {
+		return
+	}
+
+	for _, a := range alerts {
+		if !m.warned.due(a) {
+			continue
+		}
+		n := Notification{Service: a.Service, Event: a.Event, Summary: a.Summary()}
+		switch a.Event {
+		case AlertFail:
+			n.Body = a.Error
+			n.Urgency = UrgencyCritical
+		case AlertExpiring:
+			if a.ExpiresAt != nil {
+				n.Body = fmt.Sprintf("Expires at %s", a.ExpiresAt.Local().Format("15:04"))
+			}
+			n.Urgency = UrgencyNormal
+		case AlertRecover:
+			if !config.Notifications.Recover {
+				continue
+			}
+			n.Urgency = UrgencyLow
+		}
+		// Auth commands may prompt, so only offer the action with a terminal
+		if a.Event != AlertRecover && a.hasAuth && m.authTerminal() != "" {
+			n.Action = "Re-authenticate"
+		}
+		if m.send(n) {
+			m.warned.delivered(a)
+		}
+	}
+}
+
+// send shows n unless the service was notified about the same event too
+// recently, and reports whether it was shown. Limits are per event so that
+// an expiry warning or recovery never holds back a failure.
+func (m *notificationManager) send(n Notification) bool {
+	m.mu.Lock()
+	limit := DefaultNotifyRateLimit
+	if m.config.RateLimit > 0 {
+		limit = time.Duration(m.config.RateLimit) * time.Second
+	}
+	m.mu.Unlock()
+
+	now := m.now()
+	key := n.Service + "\x00" + n.Event
+	if last, ok := m.lastSent[key]; ok && now.Sub(last) < limit {
+		m.logger.Debugf("[%s] notification rate limited: %s", n.Service, n.Summary)
+		return false
+	}
+
+	if err := m.notifier.Notify(n); err != nil {
+		m.logger.Warnf("[%s] desktop notification failed: %v", n.Service, err)
+		return false
+	}
+	m.lastSent[key] = now
+
+	// Start listening for action clicks once notifications work
+	m.listen.Do(func() {
+		go func() {
+			if err := m.notifier.Listen(m.ctx, m.runAuth); err != nil && m.ctx.Err() == nil {
+				m.logger.Warnf("Notification actions unavailable: %v", err)
+			}
+		}()
+	})
+	return true
+}
+
+// terminalCommands are tried in order when notifications.terminal is not
+// set. Each runs the arguments that follow it in a new terminal window.
+var terminalCommands = []string{
+	"x-terminal-emulator -e",
+	"gnome-terminal --",
+	"konsole -e",
+	"xfce4-terminal -x",
+	"kitty --",
+	"alacritty -e",
+	"wezterm start --",
+	"foot",
+	"xterm -e",
+}
+
+// authTerminal returns the configured terminal command, or the first one
+// installed. Empty means there is no terminal to run auth in.
+func (m *notificationManager) authTerminal() string {
+	m.mu.Lock()
+	terminal := m.config.Terminal
+	m.mu.Unlock()
+	if terminal != "" {
+		return terminal
+	}
+
+	for _, candidate := range terminalCommands {
+		if _, err := exec.LookPath(strings.Fields(candidate)[0]); err == nil {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// runAuth runs 'gatekeeper auth --exact <service>' in a terminal, so that
+// auth commands can prompt and only the clicked service is re-authenticated
+func (m *notificationManager) runAuth(service string) {
+	terminal := m.authTerminal()
+	if terminal == "" {
+		m.logger.Warnf("[%s] can't re-authenticate from notification: no terminal found, set notifications.terminal", service)
+		return
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		exe = "gatekeeper"
+	}
+	cmd := exec.Command("bash", "-c", terminal+` "$0" auth --exact "$1"`, exe, service)
+
+	m.logger.Infof("[%s] re-authenticating from notification", service)
+	go func() {
+		if out, err := cmd.CombinedOutput(); err != nil {
+			m.logger.Warnf("[%s] auth from notification failed: %v%s", service, err, formatHookOutput(string(out)))
+		}
+	}()
+}
+
+// dbusNotifier talks to org.freedesktop.Notifications on the session bus
+// through gdbus, which ships with GLib on every freedesktop desktop.
+// It honors DBUS_SESSION_BUS_ADDRESS, so it can be pointed at a test bus.
+type dbusNotifier struct {
+	gdbus string
+
+	mu       sync.Mutex
+	services map[uint32]string // notification id -> service
+	ids      map[string]uint32 // service -> id of its latest notification
+}
+
+const (
+	notificationsDest = "org.freedesktop.Notifications"
+	notificationsPath = "/org/freedesktop/Notifications"
+
+	notifyActionKey = "auth"
+)
+
+func newDBusNotifier() *dbusNotifier {
+	return &dbusNotifier{
+		gdbus:    "gdbus",
+		services: make(map[uint32]string),
+		ids:      make(map[string]uint32),
+	}
+}
+
+func (d *dbusNotifier) Notify(n Notification) error {
+	d.mu.Lock()
+	replaces := d.ids[n.Service]
+	d.mu.Unlock()
+
+	actions := "@as []"
+	if n.Action != "" {
+		// "default" is invoked by clicking the notification itself
+		label := gvariantString(n.Action)
+		actions = fmt.Sprintf("['default', %s, '%s', %s]", label, notifyActionKey, label)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, d.gdbus, "call", "--session",
+		"--dest", notificationsDest,
+		"--object-path", notificationsPath,
+		"--method", notificationsDest+".Notify",
+		"--",                              // expire_timeout is negative
+		gvariantString("gatekeeper"),      // app_name
+		fmt.Sprint(replaces),              // replaces_id
+		gvariantString("dialog-password"), // app_icon
+		gvariantString(n.Summary),         // summary
+		gvariantString(n.Body),            // body
+		actions,                           // actions
+		fmt.Sprintf("{'urgency': <byte %d>}", n.Urgency), // hints
+		"-1", // expire_timeout: server default
+	).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+	}
+
+	// Reply looks like "(uint32 42,)"
+	var id uint32
+	if _, err := fmt.Sscanf(strings.TrimSpace(string(out)), "(uint32 %d,)", &id); err != nil {
+		return fmt.Errorf("unexpected reply %q", strings.TrimSpace(string(out)))
+	}
+
+	d.mu.Lock()
+	delete(d.services, replaces)
+	d.services[id] = n.Service
+	d.ids[n.Service] = id
+	d.mu.Unlock()
+	return nil
+}
+
+// Listen follows ActionInvoked and NotificationClosed signals with gdbus monitor
+func (d *dbusNotifier) Listen(ctx context.Context, onAction func(service string)) error {
+	cmd := exec.CommandContext(ctx, d.gdbus, "monitor", "--session",
+		"--dest", notificationsDest,
+		"--object-path", notificationsPath)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		line := scanner.Text()
+		// /org/freedesktop/Notifications: org.freedesktop.Notifications.ActionInvoked (uint32 42, 'auth')
+		var id uint32
+		var key string
+		if _, sig, ok := strings.Cut(line, notificationsDest+".ActionInvoked "); ok {
+			if _, err := fmt.Sscanf(sig, "(uint32 %d, %s", &id, &key); err != nil {
+				continue
+			}
+			key = strings.Trim(key, "',)")
+			if key != notifyActionKey && key != "default" {
+				continue
+			}
+
+			d.mu.Lock()
+			service, ok := d.services[id]
+			d.mu.Unlock()
+			if ok {
+				onAction(service)
+			}
+			continue
+		}
+
+		// ... org.freedesktop.Notifications.NotificationClosed (uint32 42, uint32 2)
+		if _, sig, ok := strings.Cut(line, notificationsDest+".NotificationClosed "); ok {
+			if _, err := fmt.Sscanf(sig, "(uint32 %d,", &id); err != nil {
+				continue
+			}
+			d.mu.Lock()
+			if service, ok := d.services[id]; ok {
+				delete(d.services, id)
+				if d.ids[service] == id {
+					delete(d.ids, service)
+				}
+			}
+			d.mu.Unlock()
+		}
+	}
+
+	return cmd.Wait()
+}
+
+// gvariantString quotes s as a GVariant text format string
+func gvariantString(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `'`, `\'`, "\n", `\n`, "\t", `\t`)
+	return "'" + r.Replace(s) + "'"
+}
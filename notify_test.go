@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeNotifier records notifications and lets tests click their actions
+type fakeNotifier struct {
+	mu        sync.Mutex
+	sent      []Notification
+	failures  int // number of Notify calls left to fail
+	onAction  func(service string)
+	listening chan struct{}
+}
+
+func newFakeNotifier() *fakeNotifier {
+	return &fakeNotifier{listening: make(chan struct{})}
+}
+
+func (f *fakeNotifier) Notify(n Notification) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failures > 0 {
+		f.failures--
+		return errors.New("notification server not running")
+	}
+	f.sent = append(f.sent, n)
+	return nil
+}
+
+func (f *fakeNotifier) Listen(ctx context.Context, onAction func(service string)) error {
+	f.mu.Lock()
+	f.onAction = onAction
+	f.mu.Unlock()
+	close(f.listening)
+	<-ctx.Done()
+	return nil
+}
+
+// fail makes the next n notifications fail
+func (f *fakeNotifier) fail(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failures = n
+}
+
+func (f *fakeNotifier) Sent() []Notification {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Notification(nil), f.sent...)
+}
+
+// click invokes the action as if the user clicked the notification
+func (f *fakeNotifier) click(t *testing.T, service string) {
+	t.Helper()
+	select {
+	case <-f.listening:
+	case <-time.After(5 * time.Second):
+		t.Fatal("notifier was never listened to")
+	}
+	f.mu.Lock()
+	onAction := f.onAction
+	f.mu.Unlock()
+	onAction(service)
+}
+
+func newTestNotificationManager(t *testing.T, notifier Notifier) *notificationManager {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	return newNotificationManager(ctx, notifier, NewLogger(LogDebug))
+}
+
+func TestNotificationActionRunsExactAuth(t *testing.T) {
+	notifier := newFakeNotifier()
+	m := newTestNotificationManager(t, notifier)
+
+	// A "terminal" that records the command it was asked to run
+	out := filepath.Join(t.TempDir(), "args")
+	config := &Config{Notifications: NotificationsConfig{
+		Desktop:  true,
+		Terminal: `printf '%s\n' > "` + out + `"`,
+	}}
+
+	m.Update(config, []Alert{{Event: AlertFail, Service: "AWS", Error: "exit code 1", hasAuth: true}})
+	sent := notifier.Sent()
+	if len(sent) != 1 {
+		t.Fatalf("got %d notifications, want 1", len(sent))
+	}
+	if sent[0].Action == "" || sent[0].Urgency != UrgencyCritical || sent[0].Body != "exit code 1" {
+		t.Errorf("unexpected notification %+v", sent[0])
+	}
+
+	notifier.click(t, "AWS")
+
+	var args []string
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if data, err := os.ReadFile(out); err == nil && strings.Count(string(data), "\n") == 4 {
+			args = strings.Fields(string(data))
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(args) != 4 || strings.Join(args[1:], " ") != "auth --exact AWS" {
+		t.Errorf("terminal ran %q, want <gatekeeper> auth --exact AWS", args)
+	}
+}
+
+func TestNotificationRateLimit(t *testing.T) {
+	notifier := newFakeNotifier()
+	m := newTestNotificationManager(t, notifier)
+	now := time.Date(2026, 1, 1, 9, 0, 0, 0, time.UTC)
+	m.now = func() time.Time { return now }
+
+	config := &Config{Notifications: NotificationsConfig{Desktop: true, RateLimit: 60, Terminal: "true"}}
+	fail := []Alert{{Event: AlertFail, Service: "GitHub"}}
+
+	m.Update(config, fail)
+	now = now.Add(30 * time.Second)
+	m.Update(config, fail)
+	if got := len(notifier.Sent()); got != 1 {
+		t.Errorf("got %d notifications within the rate limit, want 1", got)
+	}
+
+	now = now.Add(31 * time.Second)
+	m.Update(config, fail)
+	if got := len(notifier.Sent()); got != 2 {
+		t.Errorf("got %d notifications after the rate limit, want 2", got)
+	}
+
+	// Recoveries only with recover: true
+	m.Update(config, []Alert{{Event: AlertRecover, Service: "Vault"}})
+	if got := len(notifier.Sent()); got != 2 {
+		t.Errorf("recovery was notified without recover: true")
+	}
+
+	// An expiry warning doesn't hold back the failure that follows it
+	expires := now.Add(time.Minute)
+	m.Update(config, []Alert{{ID: "1", Event: AlertExpiring, Service: "Vault", ExpiresAt: &expires}})
+	now = now.Add(10 * time.Second)
+	m.Update(config, []Alert{{Event: AlertFail, Service: "Vault"}})
+	sent := notifier.Sent()
+	if len(sent) != 4 || sent[2].Event != AlertExpiring || sent[3].Event != AlertFail {
+		t.Errorf("got %+v, want the expiry warning and then the failure", sent[2:])
+	}
+}
+
+func TestNotificationWithoutTerminalHasNoAction(t *testing.T) {
+	notifier := newFakeNotifier()
+	m := newTestNotificationManager(t, notifier)
+	t.Setenv("PATH", t.TempDir()) // no terminal emulators installed
+
+	config := &Config{Notifications: NotificationsConfig{Desktop: true}}
+	m.Update(config, []Alert{{Event: AlertFail, Service: "AWS", hasAuth: true}})
+
+	sent := notifier.Sent()
+	if len(sent) != 1 {
+		t.Fatalf("got %d notifications, want 1", len(sent))
+	}
+	if sent[0].Action != "" {
+		t.Errorf("got action %q without a terminal, want none", sent[0].Action)
+	}
+}
+
+func TestNotificationRetriesExpiryWarning(t *testing.T) {
+	notifier := newFakeNotifier()
+	m := newTestNotificationManager(t, notifier)
+	now := time.Date(2026, 1, 1, 9, 0, 0, 0, time.UTC)
+	m.now = func() time.Time { return now }
+
+	config := &Config{
+		Services:      []Service{{Name: "AWS"}},
+		Notifications: NotificationsConfig{Desktop: true, RateLimit: 60, Terminal: "true"},
+	}
+	detector := newAlertDetector()
+	expires := time.Now().Add(10 * time.Minute)
+	expiring := []ServiceStatus{{Name: "AWS", State: StateOK, IsAlive: true, ExpiresAt: &expires}}
+	renewed := []ServiceStatus{{Name: "AWS", State: StateOK, IsAlive: true}}
+	warnings := func() int {
+		n := 0
+		for _, sent := range notifier.Sent() {
+			if strings.Contains(sent.Summary, "session expires in") {
+				n++
+			}
+		}
+		return n
+	}
+
+	// Warned once per window, however often it is checked
+	m.Update(config, detector.Detect(config, nil, expiring))
+	now = now.Add(10 * time.Second)
+	m.Update(config, detector.Detect(config, nil, expiring))
+	if got := warnings(); got != 1 {
+		t.Fatalf("got %d expiry warnings, want 1", got)
+	}
+
+	// Short-lived credentials enter the next window within the rate limit
+	m.Update(config, detector.Detect(config, nil, renewed))
+	now = now.Add(30 * time.Second)
+	m.Update(config, detector.Detect(config, nil, expiring))
+	if got := warnings(); got != 1 {
+		t.Fatalf("got %d expiry warnings within the rate limit, want 1", got)
+	}
+
+	// The rate limited warning is sent on a later check, once
+	now = now.Add(time.Minute)
+	m.Update(config, detector.Detect(config, nil, expiring))
+	now = now.Add(time.Minute)
+	m.Update(config, detector.Detect(config, nil, expiring))
+	if got := warnings(); got != 2 {
+		t.Errorf("got %d expiry warnings, want the delayed one too", got)
+	}
+
+	// So is one the desktop failed to show
+	notifier.fail(1)
+	m.Update(config, detector.Detect(config, nil, renewed))
+	now = now.Add(time.Hour)
+	m.Update(config, detector.Detect(config, nil, expiring))
+	m.Update(config, detector.Detect(config, nil, expiring))
+	if got := warnings(); got != 3 {
+		t.Errorf("got %d expiry warnings, want the one that failed to be resent", got)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// exitRunRefused is the exit code of 'run' when it doesn't start the command
+// because services aren't authenticated. Like env and docker run it uses 125,
+// which commands rarely return themselves, so scripts can tell it apart.
+const exitRunRefused = 125
+
+// isAuthenticated reports whether a result means the service can be used now
+func isAuthenticated(st ServiceStatus) bool {
+	return statusState(st) == StateOK && !isExpired(st)
+}
+
+// unreadyServices returns the results of services that aren't authenticated.
+// Results in state.json up to maxAge old are trusted; the rest are checked now.
+// Disabled services, such as a dependency that is switched off, don't block
+// anything, as in CheckBatchFunc.
+func unreadyServices(config *Config, services []Service, maxAge time.Duration) []ServiceStatus {
+	cached := make(map[string]ServiceStatus)
+	if maxAge > 0 {
+		if state, err := readStateFile(); err == nil {
+			for _, st := range state.Services {
+				cached[st.Name] = st
+			}
+		}
+	}
+
+	now := time.Now()
+	var stale []Service
+	for _, svc := range services {
+		st, ok := cached[svc.Name]
+		if ok && isAuthenticated(st) && now.Sub(st.LastChecked) <= maxAge {
+			continue
+		}
+		stale = append(stale, svc)
+	}
+	if len(stale) == 0 {
+		return nil
+	}
+
+	var failing []ServiceStatus
+	for _, st := range checkNow(config, stale) {
+		if statusState(st) != StateDisabled && !isAuthenticated(st) {
+			failing = append(failing, st)
+		}
+	}
+	return failing
+}
+
+// isInteractive reports whether stdin is a terminal someone can answer prompts on
+func isInteractive() bool {
+	info, err := os.Stdin.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	// /dev/null is a character device too
+	null, err := os.Stat(os.DevNull)
+	return err != nil || !os.SameFile(info, null)
+}
+
+// confirm asks a yes/no question on stderr; an empty answer means yes
+func confirm(question string) bool {
+	fmt.Fprintf(os.Stderr, "%s [Y/n] ", question)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "" || answer == "y" || answer == "yes"
+}
+
+// runChild runs argv attached to our stdio and returns its exit code.
+// SIGTERM and SIGHUP are passed on. In a terminal the child gets Ctrl-C
+// from the terminal itself, so SIGINT is only forwarded otherwise.
+func runChild(argv []string) int {
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	sigs := make(chan os.Signal, 4)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(sigs)
+
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "gatekeeper: %v\n", err)
+		if errors.Is(err, exec.ErrNotFound) {
+			return exitNotFound
+		}
+		return exitNotExecutable
+	}
+
+	interactive := isInteractive()
+	go func() {
+		for sig := range sigs {
+			if sig == os.Interrupt && interactive {
+				continue
+			}
+			cmd.Process.Signal(sig)
+		}
+	}()
+
+	err := cmd.Wait()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			return 128 + int(ws.Signal())
+		}
+		return exitErr.ExitCode()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "gatekeeper: %v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"slices"
+	"time"
+)
+
+// scheduler tracks when each service was last checked and derives when it
+// is due again from the current config, so interval changes on reload take
+// effect without losing track of recent checks. Intervals run start-to-start
+// like a ticker, so slow checks don't drift.
+type scheduler struct {
+	started map[string]time.Time
+}
+
+func newScheduler() *scheduler {
+	return &scheduler{started: make(map[string]time.Time)}
+}
+
+// serviceInterval returns the check interval for a service,
+// falling back to the global interval
+func serviceInterval(config *Config, svc Service) time.Duration {
+	if svc.Interval > 0 {
+		return time.Duration(svc.Interval) * time.Second
+	}
+	return time.Duration(config.Interval) * time.Second
+}
+
+// Sync forgets services that are no longer in config
+func (s *scheduler) Sync(config *Config) {
+	for name := range s.started {
+		if !slices.ContainsFunc(config.Services, func(svc Service) bool { return svc.Name == name }) {
+			delete(s.started, name)
+		}
+	}
+}
+
+// Forget makes the named services due immediately
+func (s *scheduler) Forget(names []string) {
+	for _, name := range names {
+		delete(s.started, name)
+	}
+}
+
+// Next returns when svc is due; never-checked services are due immediately
+func (s *scheduler) Next(config *Config, svc Service) time.Time {
+	started, ok := s.started[svc.Name]
+	if !ok {
+		return time.Time{}
+	}
+	return started.Add(serviceInterval(config, svc))
+}
+
+// Due returns the names of services whose next check is at or before now
+func (s *scheduler) Due(config *Config, now time.Time) []string {
+	var due []string
+	for _, svc := range config.Services {
+		if !s.Next(config, svc).After(now) {
+			due = append(due, svc.Name)
+		}
+	}
+	return due
+}
+
+// Started records that the named services (all if names is nil)
+// are being checked at now
+func (s *scheduler) Started(config *Config, names []string, now time.Time) {
+	for _, svc := range config.Services {
+		if names == nil || slices.Contains(names, svc.Name) {
+			s.started[svc.Name] = now
+		}
+	}
+}
+
+// NextWake returns the earliest time any service is due,
+// or false if there is nothing to schedule
+func (s *scheduler) NextWake(config *Config) (time.Time, bool) {
+	var earliest time.Time
+	for i, svc := range config.Services {
+		next := s.Next(config, svc)
+		if i == 0 || next.Before(earliest) {
+			earliest = next
+		}
+	}
+	return earliest, len(config.Services) > 0
+}
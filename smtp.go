@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// SMTP connection security
+const (
+	SMTPStartTLS = "starttls" // upgrade a plain connection (default, port 587)
+	SMTPTLS      = "tls"      // TLS from the start (port 465)
+	SMTPNone     = "none"     // no encryption, e.g. a local relay
+)
+
+const (
+	DefaultSMTPPort        = 587
+	DefaultSMTPQuietPeriod = 15 * time.Minute
+	DefaultSMTPSubject     = `[gatekeeper] {{if .Failing}}{{len .Failing}} failing{{else}}all clear{{end}} on {{.Host}}`
+)
+
+// SMTPConfig configures an smtp notifier
+type SMTPConfig struct {
+	Host        string   `yaml:"host"`
+	Port        int      `yaml:"port"`     // default: 587, or 465 with tls: tls
+	TLS         string   `yaml:"tls"`      // starttls (default), tls or none
+	Username    string   `yaml:"username"` // environment variables are expanded
+	Password    string   `yaml:"password"` // environment variables are expanded
+	From        string   `yaml:"from"`
+	To          []string `yaml:"to"`
+	Subject     string   `yaml:"subject"`      // Go template, see DefaultSMTPSubject
+	QuietPeriod int      `yaml:"quiet_period"` // minimum seconds between digests (default: 900)
+}
+
+// Digest is what an email reports: the alerts since the last mail and
+// every service that is failing now
+type Digest struct {
+	Host    string
+	Time    time.Time
+	Alerts  []Alert
+	Failing []ServiceStatus
+}
+
+func parseSubjectTemplate(text string) (*template.Template, error) {
+	return template.New("subject").Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+}
+
+// lastSentPath is where the time of each notifier's last digest is kept,
+// next to the outbox, so a restart doesn't cut the quiet period short
+func (o *alertOutbox) lastSentPath() string {
+	return filepath.Join(filepath.Dir(o.dir), "digests.json")
+}
+
+func (o *alertOutbox) loadLastSent() {
+	data, err := os.ReadFile(o.lastSentPath())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			o.logger.Warnf("Error reading digest times: %v", err)
+		}
+		return
+	}
+	if err := json.Unmarshal(data, &o.lastSent); err != nil {
+		o.logger.Warnf("Ignoring corrupt %s: %v", filepath.Base(o.lastSentPath()), err)
+	}
+}
+
+// saveLastSent writes the digest times; callers hold o.mu
+func (o *alertOutbox) saveLastSent() error {
+	data, err := json.MarshalIndent(o.lastSent, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(o.lastSentPath()), 0700); err != nil {
+		return err
+	}
+	return writeFileAtomic(o.lastSentPath(), data, 0600)
+}
+
+// quietUntil returns when an email notifier may send its next digest
+func (o *alertOutbox) quietUntil(n NotifierConfig) time.Time {
+	quiet := DefaultSMTPQuietPeriod
+	if n.SMTP != nil && n.SMTP.QuietPeriod > 0 {
+		quiet = time.Duration(n.SMTP.QuietPeriod) * time.Second
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	last, ok := o.lastSent[n.Name]
+	if !ok {
+		return time.Time{}
+	}
+	return last.Add(quiet)
+}
+
+// sendDigest mails one message covering the alerts and all failing services
+func (o *alertOutbox) sendDigest(ctx context.Context, n NotifierConfig, alerts []Alert) error {
+	if n.SMTP == nil {
+		return &permanentError{fmt.Errorf("no smtp settings")}
+	}
+
+	digest := Digest{Host: alerts[0].Host, Time: time.Now(), Alerts: alerts}
+	digest.Failing = o.failingServices(n)
+
+	msg, err := buildDigestMessage(n.SMTP, digest)
+	if err != nil {
+		return &permanentError{err}
+	}
+
+	timeout := DefaultNotifierTimeout
+	if n.Timeout > 0 {
+		timeout = time.Duration(n.Timeout) * time.Second
+	}
+	if err := sendMail(ctx, n.SMTP, msg, timeout); err != nil {
+		return err
+	}
+
+	o.mu.Lock()
+	o.lastSent[n.Name] = time.Now()
+	if err := o.saveLastSent(); err != nil {
+		o.logger.Warnf("Error saving digest times: %v", err)
+	}
+	o.mu.Unlock()
+	return nil
+}
+
+// failingServices returns the failing services from state.json that the
+// notifier cares about
+func (o *alertOutbox) failingServices(n NotifierConfig) []ServiceStatus {
+	state, err := readStateFile()
+	if err != nil {
+		return nil
+	}
+
+	minSeverity := n.MinSeverity
+	if minSeverity == "" {
+		minSeverity = SeverityWarning
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var failing []ServiceStatus
+	for _, st := range state.Services {
+		severity, ok := o.severities[st.Name]
+		if ok && isFailing(statusState(st)) && severityRank(severity) >= severityRank(minSeverity) {
+			failing = append(failing, st)
+		}
+	}
+	return failing
+}
+
+// buildDigestMessage renders the digest as a plain text email
+func buildDigestMessage(cfg *SMTPConfig, d Digest) ([]byte, error) {
+	subjectTmpl := cfg.Subject
+	if subjectTmpl == "" {
+		subjectTmpl = DefaultSMTPSubject
+	}
+	tmpl, err := parseSubjectTemplate(subjectTmpl)
+	if err != nil {
+		return nil, err
+	}
+	var subject bytes.Buffer
+	if err := tmpl.Execute(&subject, d); err != nil {
+		return nil, err
+	}
+
+	var body strings.Builder
+	if len(d.Failing) > 0 {
+		body.WriteString("Failing now:\r\n")
+		for _, st := range d.Failing {
+			line := fmt.Sprintf("  %s: %s", st.Name, statusState(st))
+			if st.Error != "" {
+				line += " (" + st.Error + ")"
+			}
+			body.WriteString(line + "\r\n")
+		}
+	} else {
+		body.WriteString("No services are failing.\r\n")
+	}
+
+	body.WriteString("\r\nSince the last report:\r\n")
+	for _, a := range d.Alerts {
+		line := fmt.Sprintf("  %s  %s", a.Time.Local().Format("2006-01-02 15:04"), a.Summary())
+		if a.Event == AlertFail && a.Error != "" {
+			line += " (" + a.Error + ")"
+		}
+		body.WriteString(line + "\r\n")
+	}
+	body.WriteString(fmt.Sprintf("\r\n-- \r\ngatekeeper v%s on %s\r\n", Version, d.Host))
+
+	var msg bytes.Buffer
+	headers := [][2]string{
+		{"From", cfg.From},
+		{"To", strings.Join(cfg.To, ", ")},
+		{"Subject", mime.QEncoding.Encode("utf-8", strings.TrimSpace(subject.String()))},
+		{"Date", d.Time.Format(time.RFC1123Z)},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "text/plain; charset=utf-8"},
+		{"Content-Transfer-Encoding", "8bit"},
+	}
+	for _, h := range headers {
+		fmt.Fprintf(&msg, "%s: %s\r\n", h[0], h[1])
+	}
+	msg.WriteString("\r\n")
+	msg.WriteString(body.String())
+	return msg.Bytes(), nil
+}
+
+// sendMail delivers msg through the configured server. net/smtp only
+// sends credentials over TLS or to localhost.
+func sendMail(ctx context.Context, cfg *SMTPConfig, msg []byte, timeout time.Duration) error {
+	mode := cfg.TLS
+	if mode == "" {
+		mode = SMTPStartTLS
+	}
+	port := cfg.Port
+	if port == 0 {
+		port = DefaultSMTPPort
+		if mode == SMTPTLS {
+			port = 465
+		}
+	}
+	addr := net.JoinHostPort(cfg.Host, strconv.Itoa(port))
+	tlsConfig := &tls.Config{ServerName: cfg.Host}
+
+	dialer := &net.Dialer{Timeout: timeout}
+	var conn net.Conn
+	var err error
+	if mode == SMTPTLS {
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: tlsConfig}
+		conn, err = tlsDialer.DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	c, err := smtp.NewClient(conn, cfg.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if mode == SMTPStartTLS {
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("STARTTLS: %w", err)
+		}
+	}
+
+	if cfg.Username != "" {
+		auth := smtp.PlainAuth("", os.ExpandEnv(cfg.Username), os.ExpandEnv(cfg.Password), cfg.Host)
+		if err := c.Auth(auth); err != nil {
+			return &permanentError{fmt.Errorf("auth: %w", err)}
+		}
+	}
+
+	if err := c.Mail(cfg.From); err != nil {
+		return err
+	}
+	for _, to := range cfg.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// smtpStub is a minimal in-process SMTP server that records the messages
+// it accepts. It offers neither STARTTLS nor AUTH.
+type smtpStub struct {
+	ln net.Listener
+
+	mu       sync.Mutex
+	messages []smtpMessage
+}
+
+type smtpMessage struct {
+	From string
+	To   []string
+	Data string
+}
+
+func newSMTPStub(t *testing.T) *smtpStub {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpStub{ln: ln}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *smtpStub) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+	reply("220 stub ESMTP")
+	var msg smtpMessage
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		verb := strings.ToUpper(strings.Fields(line + " ")[0])
+		switch verb {
+		case "EHLO", "HELO":
+			reply("250 stub")
+		case "MAIL":
+			msg = smtpMessage{From: strings.TrimPrefix(line, "MAIL FROM:")}
+			reply("250 OK")
+		case "RCPT":
+			msg.To = append(msg.To, strings.TrimPrefix(line, "RCPT TO:"))
+			reply("250 OK")
+		case "DATA":
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(line)
+			}
+			msg.Data = data.String()
+			s.mu.Lock()
+			s.messages = append(s.messages, msg)
+			s.mu.Unlock()
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+func (s *smtpStub) Messages() []smtpMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]smtpMessage(nil), s.messages...)
+}
+
+func (s *smtpStub) config() *SMTPConfig {
+	return &SMTPConfig{
+		Host:        "127.0.0.1",
+		Port:        s.ln.Addr().(*net.TCPAddr).Port,
+		TLS:         SMTPNone,
+		From:        "gatekeeper@example.com",
+		To:          []string{"oncall@example.com", "me@example.com"},
+		QuietPeriod: 3600,
+	}
+}
+
+func TestSMTPDigest(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	stub := newSMTPStub(t)
+	dir := filepath.Join(t.TempDir(), "outbox")
+	notifier := NotifierConfig{Name: "email", Type: NotifierSMTP, SMTP: stub.config()}
+
+	// Alerts due together go out as one mail
+	o := newTestOutbox(t, dir, notifier)
+	gh, vault := testAlert("GitHub"), testAlert("Vault")
+	gh.Host, vault.Host = "laptop", "laptop"
+	o.Enqueue([]Alert{gh, vault})
+	if next := o.deliverDue(context.Background()); !next.IsZero() {
+		t.Errorf("got a retry at %s after sending the digest", next)
+	}
+
+	messages := stub.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("got %d mails, want 1", len(messages))
+	}
+	msg := messages[0]
+	if msg.From != "<gatekeeper@example.com>" || len(msg.To) != 2 {
+		t.Errorf("got envelope from %s to %v", msg.From, msg.To)
+	}
+	for _, want := range []string{
+		"Subject: [gatekeeper] all clear on laptop",
+		"To: oncall@example.com, me@example.com",
+		gh.Summary() + " (exit code 1)",
+		vault.Summary() + " (exit code 1)",
+	} {
+		if !strings.Contains(msg.Data, want) {
+			t.Errorf("mail does not contain %q:\n%s", want, msg.Data)
+		}
+	}
+
+	// Later alerts wait for the quiet period, also after a restart
+	o.Enqueue([]Alert{testAlert("AWS")})
+	next := o.deliverDue(context.Background())
+	restarted := newTestOutbox(t, dir, notifier)
+	nextAfterRestart := restarted.deliverDue(context.Background())
+	if got := len(stub.Messages()); got != 1 {
+		t.Fatalf("got %d mails during the quiet period, want 1", got)
+	}
+	for _, at := range []time.Time{next, nextAfterRestart} {
+		if until := time.Until(at); until < 59*time.Minute || until > time.Hour {
+			t.Errorf("next digest in %s, want the one hour quiet period", until)
+		}
+	}
+	if entries := pendingEntries(t, restarted); len(entries) != 1 {
+		t.Errorf("got %d pending alerts, want the AWS alert", len(entries))
+	}
+}
+
+func TestSendMailHonorsContext(t *testing.T) {
+	// Accepts connections but never completes a TLS handshake
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		var conns []net.Conn
+		defer func() {
+			for _, conn := range conns {
+				conn.Close()
+			}
+		}()
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conns = append(conns, conn)
+		}
+	}()
+
+	cfg := &SMTPConfig{Host: "127.0.0.1", Port: ln.Addr().(*net.TCPAddr).Port, TLS: SMTPTLS}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err = sendMail(ctx, cfg, []byte("test"), time.Minute)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want the context deadline", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("sendMail took %s after the context expired", elapsed)
+	}
+}
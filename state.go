@@ -2,6 +2,8 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"syscall"
@@ -9,8 +11,9 @@ import (
 )
 
 type State struct {
-	Daemon   *DaemonStatus  `json:"daemon"`
-	Services []ServiceStatus `json:"services"`
+	SchemaVersion int             `json:"schema_version"`
+	Daemon        *DaemonStatus   `json:"daemon"`
+	Services      []ServiceStatus `json:"services"`
 }
 
 type DaemonStatus struct {
@@ -43,18 +46,47 @@ func isProcessRunning(pid int) bool {
 	return err == nil
 }
 
-func loadState() (*State, error) {
-	path := getStatePath()
-	data, err := os.ReadFile(path)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return &State{}, nil
+// Readers retry briefly when they catch a file mid-write. Writes are
+// atomic renames, but other tools or older versions may still truncate.
+const (
+	stateReadAttempts = 5
+	stateReadBackoff  = 20 * time.Millisecond
+)
+
+// readStateFile reads state.json as-is; a missing file is an empty state
+func readStateFile() (*State, error) {
+	var lastErr error
+	for attempt := 0; attempt < stateReadAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(stateReadBackoff)
 		}
-		return nil, err
+
+		data, err := os.ReadFile(getStatePath())
+		if err != nil {
+			if os.IsNotExist(err) {
+				return &State{SchemaVersion: StateSchemaVersion}, nil
+			}
+			return nil, err
+		}
+
+		state, err := decodeState(data)
+		var versionErr *stateVersionError
+		if errors.As(err, &versionErr) {
+			return nil, err
+		}
+		if err != nil {
+			// Empty or truncated file: a write is likely in progress
+			lastErr = fmt.Errorf("state file is incomplete or corrupt: %w", err)
+			continue
+		}
+		return state, nil
 	}
+	return nil, lastErr
+}
 
-	var state State
-	if err := json.Unmarshal(data, &state); err != nil {
+func loadState() (*State, error) {
+	state, err := readStateFile()
+	if err != nil {
 		return nil, err
 	}
 
@@ -63,15 +95,34 @@ func loadState() (*State, error) {
 		if !isProcessRunning(state.Daemon.PID) {
 			// Process not running, update state
 			state.Daemon.Running = false
-			// Save corrected state back to file
-			saveState(&state)
+
+			// Save corrected state back to file, re-reading under the lock
+			// in case a new daemon wrote in the meantime
+			pid := state.Daemon.PID
+			updateState(func(current *State) {
+				if current.Daemon != nil && current.Daemon.PID == pid {
+					current.Daemon.Running = false
+				}
+			})
 		}
 	}
 
-	return &state, nil
+	return state, nil
 }
 
+// saveState writes the state while holding the state lock
 func saveState(state *State) error {
+	unlock, err := lockState()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return writeStateFile(state)
+}
+
+// writeStateFile atomically replaces state.json; callers hold the state lock
+func writeStateFile(state *State) error {
 	path := getStatePath()
 	dir := filepath.Dir(path)
 
@@ -80,10 +131,94 @@ func saveState(state *State) error {
 		return err
 	}
 
+	state.SchemaVersion = StateSchemaVersion
 	data, err := json.MarshalIndent(state, "", "  ")
 	if err != nil {
 		return err
 	}
 
-	return os.WriteFile(path, data, 0644)
+	return writeFileAtomic(path, data, 0644)
+}
+
+// writeFileAtomic writes data to a temp file in the same directory and
+// renames it over path, so readers see either the old or the new file
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	// Clean up the temp file on any failure
+	ok := false
+	defer func() {
+		if !ok {
+			tmp.Close()
+			os.Remove(tmpPath)
+		}
+	}()
+
+	if _, err := tmp.Write(data); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpPath, perm); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+
+	ok = true
+	return nil
+}
+
+func getStateLockPath() string {
+	return getStatePath() + ".lock"
+}
+
+// lockState takes the state lock shared by the daemon and CLI.
+// Call the returned function to release it.
+func lockState() (func(), error) {
+	path := getStateLockPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return func() {
+		unlockFile(f)
+		f.Close()
+	}, nil
+}
+
+// updateState loads the state, applies fn and saves it while holding
+// the state lock, so concurrent writers don't lose each other's changes
+func updateState(fn func(state *State)) error {
+	unlock, err := lockState()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	state, err := readStateFile()
+	if err != nil {
+		return err
+	}
+
+	fn(state)
+	return writeStateFile(state)
 }
@@ -0,0 +1,15 @@
+//go:build windows
+
+package main
+
+import "os"
+
+// Advisory locking is not implemented on Windows; the daemon is the
+// only regular writer there, so state updates are best effort.
+func lockFile(f *os.File) error {
+	return nil
+}
+
+func unlockFile(f *os.File) error {
+	return nil
+}
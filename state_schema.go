@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// StateSchemaVersion is the version of the state.json format written by this
+// build. Bump it for changes that older readers can't ignore (renamed or
+// retyped fields, changed meaning, new state values, which consumers may
+// validate against the schema's enum) and add a migration from the previous
+// version. Adding optional fields does not need a bump.
+//
+// Version 2 added the blocked state.
+const StateSchemaVersion = 2
+
+// stateMigrations[v] upgrades a state decoded from version v to v+1.
+// Files written before schema_version existed are version 0.
+var stateMigrations = []func(*State){
+	migrateStateV0,
+	migrateStateV1,
+}
+
+// migrateStateV0 fills in state, which version 0 files only had as is_alive
+func migrateStateV0(state *State) {
+	for i := range state.Services {
+		state.Services[i].State = statusState(state.Services[i])
+	}
+}
+
+// migrateStateV1 has nothing to do: version 2 only added the blocked state,
+// which version 1 files never contain
+func migrateStateV1(state *State) {}
+
+// decodeState parses state.json, refusing versions newer than this build
+// understands and migrating older ones to the current version
+func decodeState(data []byte) (*State, error) {
+	var header struct {
+		SchemaVersion int `json:"schema_version"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, err
+	}
+
+	version := header.SchemaVersion
+	if version > StateSchemaVersion {
+		return nil, &stateVersionError{Version: version}
+	}
+	if version < 0 {
+		return nil, fmt.Errorf("invalid schema_version %d", version)
+	}
+
+	var state State
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+
+	for v := version; v < StateSchemaVersion; v++ {
+		stateMigrations[v](&state)
+	}
+	state.SchemaVersion = StateSchemaVersion
+	return &state, nil
+}
+
+// stateVersionError means state.json was written by a newer gatekeeper
+type stateVersionError struct {
+	Version int
+}
+
+func (e *stateVersionError) Error() string {
+	return fmt.Sprintf("state file has schema_version %d, but this gatekeeper (v%s) only understands up to %d; upgrade gatekeeper or restart the daemon with this version",
+		e.Version, Version, StateSchemaVersion)
+}
+
+// stateJSONSchema documents state.json and the output of status --json.
+// Keep it in sync with State, DaemonStatus and ServiceStatus.
+const stateJSONSchema = `{
+  "$schema": "https://json-schema.org/draft/2020-12/schema",
+  "title": "gatekeeper state",
+  "description": "Contents of ~/.cache/gatekeeper/state.json and the output of 'gatekeeper status --json'. Optional fields may be added within a schema_version, so consumers should ignore unknown fields. New state values come with a new schema_version.",
+  "type": "object",
+  "required": ["schema_version", "daemon", "services"],
+  "properties": {
+    "schema_version": {
+      "description": "Format version. Files without it are version 0. Version 2 added the blocked state.",
+      "const": 2
+    },
+    "daemon": {
+      "oneOf": [
+        { "type": "null" },
+        {
+          "type": "object",
+          "required": ["running", "pid", "started_at", "last_check"],
+          "properties": {
+            "running": { "type": "boolean" },
+            "pid": { "type": "integer" },
+            "started_at": { "type": "string", "format": "date-time" },
+            "last_check": { "type": "string", "format": "date-time" }
+          }
+        }
+      ]
+    },
+    "services": {
+      "oneOf": [
+        { "type": "null" },
+        { "type": "array", "items": { "$ref": "#/$defs/service" } }
+      ]
+    }
+  },
+  "$defs": {
+    "service": {
+      "type": "object",
+      "required": ["name", "state", "is_alive", "last_checked", "next_check"],
+      "properties": {
+        "name": { "type": "string" },
+        "state": {
+          "description": "'blocked' is new in version 2.",
+          "enum": ["ok", "unauthenticated", "timeout", "error", "unknown", "blocked", "disabled"]
+        },
+        "is_alive": {
+          "description": "Same as state == ok, kept for older clients.",
+          "type": "boolean"
+        },
+        "error": { "type": "string" },
+        "icon": { "type": "string" },
+        "group": { "type": "string" },
+        "tags": { "type": "array", "items": { "type": "string" } },
+        "attempt": {
+          "description": "Attempt that produced the result.",
+          "type": "integer",
+          "minimum": 1
+        },
+        "duration_ms": {
+          "description": "Total time spent, including retries.",
+          "type": "integer",
+          "minimum": 0
+        },
+        "exit_code": {
+          "description": "check_cmd exit code of the last attempt.",
+          "type": "integer"
+        },
+        "output": {
+          "description": "Redacted tail of the check output when the check failed.",
+          "type": "string"
+        },
+        "last_checked": { "type": "string", "format": "date-time" },
+        "next_check": { "type": "string", "format": "date-time" },
+        "expires_at": { "type": "string", "format": "date-time" },
+        "expiry_warning": {
+          "description": "Seconds before expires_at at which the session counts as expiring soon.",
+          "type": "integer",
+          "minimum": 0
+        }
+      }
+    }
+  }
+}
+`
+
+// jsonSchemas maps the names accepted by 'gatekeeper schema' to their schema
+var jsonSchemas = map[string]string{
+	"state": stateJSONSchema,
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+)
+
+// TestStateSchemaListsEveryState keeps the published enum in sync with
+// checkStates, so a new state is documented when it is added
+func TestStateSchemaListsEveryState(t *testing.T) {
+	var schema struct {
+		Defs struct {
+			Service struct {
+				Properties struct {
+					State struct {
+						Enum []CheckState `json:"enum"`
+					} `json:"state"`
+				} `json:"properties"`
+			} `json:"service"`
+		} `json:"$defs"`
+	}
+	if err := json.Unmarshal([]byte(stateJSONSchema), &schema); err != nil {
+		t.Fatal(err)
+	}
+
+	enum := schema.Defs.Service.Properties.State.Enum
+	for _, state := range checkStates {
+		if !slices.Contains(enum, state) {
+			t.Errorf("state %q is missing from the schema enum", state)
+		}
+	}
+	if len(enum) != len(checkStates) {
+		t.Errorf("schema enum %v doesn't match states %v", enum, checkStates)
+	}
+}
+
+func TestDecodeStateVersions(t *testing.T) {
+	// Version 0 files only had is_alive
+	state, err := decodeState([]byte(`{"services": [{"name": "GitHub", "is_alive": true}, {"name": "AWS", "is_alive": false}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.SchemaVersion != StateSchemaVersion || state.Services[0].State != StateOK || state.Services[1].State != StateUnauthenticated {
+		t.Errorf("version 0 not migrated: %+v", state)
+	}
+
+	// Version 1 had every state but blocked
+	state, err = decodeState([]byte(`{"schema_version": 1, "services": [{"name": "Vault", "state": "unauthenticated"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.SchemaVersion != StateSchemaVersion || state.Services[0].State != StateUnauthenticated {
+		t.Errorf("version 1 not migrated: %+v", state)
+	}
+
+	if _, err := decodeState([]byte(`{"schema_version": 99}`)); err == nil {
+		t.Error("a newer schema_version was accepted")
+	}
+}
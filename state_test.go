@@ -0,0 +1,174 @@
+//go:build !windows
+
+// State locking is a no-op on Windows, see state_lock_windows.go
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestStateConcurrentAccess runs the daemon's writes and CLI writers against
+// many status readers. Readers must never see a partial file, and the daemon
+// must not overwrite results the CLI saved after its own checks.
+func TestStateConcurrentAccess(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	const (
+		writers       = 4
+		updatesPerCLI = 10
+		readers       = 8
+		daemonTicks   = 30
+	)
+
+	// The daemon checked every service when it started
+	started := time.Now()
+	var services []Service
+	var daemonResults []ServiceStatus
+	for w := 0; w < writers; w++ {
+		for i := 0; i < updatesPerCLI; i++ {
+			name := fmt.Sprintf("svc-%d-%d", w, i)
+			services = append(services, Service{Name: name})
+			daemonResults = append(daemonResults, ServiceStatus{Name: name, State: StateUnauthenticated, LastChecked: started})
+		}
+	}
+	daemon := &DaemonStatus{Running: true, PID: os.Getpid(), StartedAt: started}
+	if err := saveState(&State{Daemon: daemon, Services: daemonResults}); err != nil {
+		t.Fatal(err)
+	}
+
+	stop := make(chan struct{})
+	errs := make(chan error, writers+readers+1)
+	var wg, readWg sync.WaitGroup
+
+	// The daemon rewrites the whole file on every tick, as checkAndUpdateState does
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < daemonTicks; i++ {
+			state := &State{Daemon: daemon, Services: daemonResults}
+			state.Daemon.LastCheck = time.Now()
+			if err := saveDaemonState(state); err != nil {
+				errs <- fmt.Errorf("daemon save: %w", err)
+				return
+			}
+			daemonResults = state.Services
+		}
+	}()
+
+	// CLI writers, e.g. 'check --update-state' saving newer results
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < updatesPerCLI; i++ {
+				result := ServiceStatus{Name: fmt.Sprintf("svc-%d-%d", w, i), State: StateOK, LastChecked: time.Now()}
+				err := updateState(func(state *State) {
+					state.Services = mergeStatuses(services, []ServiceStatus{result}, state)
+				})
+				if err != nil {
+					errs <- fmt.Errorf("writer %d: %w", w, err)
+					return
+				}
+			}
+		}(w)
+	}
+
+	// tmux refreshing 'gatekeeper status'
+	for r := 0; r < readers; r++ {
+		readWg.Add(1)
+		go func() {
+			defer readWg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				state, err := loadState()
+				if err != nil {
+					errs <- fmt.Errorf("reader: %w", err)
+					return
+				}
+				if state.Daemon == nil || !state.Daemon.Running {
+					errs <- fmt.Errorf("reader saw daemon status %+v", state.Daemon)
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(stop)
+	readWg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	state, err := readStateFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(state.Services), writers*updatesPerCLI; got != want {
+		t.Errorf("got %d services after concurrent updates, want %d", got, want)
+	}
+	seen := make(map[string]bool)
+	for _, st := range state.Services {
+		if seen[st.Name] {
+			t.Errorf("service %s saved twice", st.Name)
+		}
+		seen[st.Name] = true
+		if st.State != StateOK {
+			t.Errorf("%s: the daemon overwrote the CLI's newer result", st.Name)
+		}
+	}
+}
+
+// TestStateSaveIsAtomic hammers saveState while reading the raw file.
+// Without retries a reader must still never decode a partial write.
+func TestStateSaveIsAtomic(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	services := make([]ServiceStatus, 50)
+	for i := range services {
+		services[i] = ServiceStatus{Name: fmt.Sprintf("svc-%d", i), State: StateOK, Output: "some output to make the file larger"}
+	}
+	if err := saveState(&State{Services: services}); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			if err := saveState(&State{Services: services}); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		data, err := os.ReadFile(getStatePath())
+		if err != nil {
+			t.Fatal(err)
+		}
+		state, err := decodeState(data)
+		if err != nil {
+			t.Fatalf("decoded a partial write: %v", err)
+		}
+		if len(state.Services) != len(services) {
+			t.Fatalf("got %d services, want %d", len(state.Services), len(services))
+		}
+	}
+}
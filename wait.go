@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+)
+
+const DefaultWaitInterval = 5 * time.Second
+
+// waitTracker follows services until each has reached the wanted state
+// and reports progress on stderr
+type waitTracker struct {
+	want  CheckState
+	order []string              // services in config order
+	seen  map[string]CheckState // last state reported per service
+	done  map[string]bool
+
+	notBefore time.Time // ignore results checked earlier than this
+}
+
+func newWaitTracker(services []Service, want CheckState) *waitTracker {
+	w := &waitTracker{want: want, seen: make(map[string]CheckState), done: make(map[string]bool)}
+	for _, svc := range services {
+		w.order = append(w.order, svc.Name)
+	}
+	return w
+}
+
+// reached reports whether a result is what we're waiting for. Waiting for
+// ok also requires the session not to have expired.
+func (w *waitTracker) reached(st ServiceStatus) bool {
+	if w.want == StateOK {
+		return isAuthenticated(st)
+	}
+	return statusState(st) == w.want
+}
+
+// Update takes new results and returns true once every service is done.
+// Disabled services are done as soon as they're seen.
+func (w *waitTracker) Update(statuses []ServiceStatus) bool {
+	for _, st := range statuses {
+		if !slices.Contains(w.order, st.Name) || w.done[st.Name] {
+			continue
+		}
+		if st.LastChecked.Before(w.notBefore) && statusState(st) != StateDisabled {
+			continue
+		}
+		state := statusState(st)
+		if w.reached(st) {
+			w.done[st.Name] = true
+			fmt.Fprintf(os.Stderr, "%s %s: %s\n", state.Symbol(), st.Name, state)
+			continue
+		}
+		// A disabled service is never checked, so it can't get there
+		if state == StateDisabled {
+			w.done[st.Name] = true
+			fmt.Fprintf(os.Stderr, "%s %s: disabled, not waiting for it\n", state.Symbol(), st.Name)
+			continue
+		}
+		if prev, ok := w.seen[st.Name]; !ok || prev != state {
+			fmt.Fprintf(os.Stderr, "%s %s: %s, waiting for %s...\n", state.Symbol(), st.Name, state, w.want)
+		}
+		w.seen[st.Name] = state
+	}
+	return len(w.Pending()) == 0
+}
+
+// Pending returns the services that haven't reached the wanted state yet
+func (w *waitTracker) Pending() []string {
+	var pending []string
+	for _, name := range w.order {
+		if !w.done[name] {
+			pending = append(pending, name)
+		}
+	}
+	return pending
+}
+
+// waitWithDaemon follows the daemon's state over the control socket. Every
+// interval it asks the daemon to re-check the pending services, so a login
+// finished elsewhere is noticed without waiting for the daemon's schedule.
+// Only results from those checks count, not ones from before the wait.
+func waitWithDaemon(ctx context.Context, w *waitTracker, interval time.Duration) error {
+	w.notBefore = time.Now()
+
+	conn, err := dialControl()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := json.NewEncoder(conn).Encode(ControlRequest{Cmd: ControlSubscribe}); err != nil {
+		return err
+	}
+
+	updates := make(chan *State)
+	failed := make(chan error, 1)
+	go func() {
+		dec := json.NewDecoder(conn)
+		for {
+			var resp ControlResponse
+			if err := dec.Decode(&resp); err != nil {
+				failed <- fmt.Errorf("lost connection to daemon: %w", err)
+				return
+			}
+			if !resp.OK {
+				failed <- fmt.Errorf("%s", resp.Error)
+				return
+			}
+			select {
+			case updates <- resp.State:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	checking := make(chan struct{}, 1) // one check request in flight at a time
+	nudge := func() {
+		select {
+		case checking <- struct{}{}:
+		default:
+			return
+		}
+		pending := w.Pending()
+		go func() {
+			defer func() { <-checking }()
+			sendControl(ControlRequest{Cmd: ControlCheck, Services: pending}, 2*time.Minute)
+		}()
+	}
+	nudge()
+
+	for {
+		select {
+		case state := <-updates:
+			if state != nil && w.Update(state.Services) {
+				return nil
+			}
+		case <-ticker.C:
+			nudge()
+		case err := <-failed:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// waitOnStateFile follows state.json, for a daemon without a control socket
+func waitOnStateFile(ctx context.Context, w *waitTracker) error {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		if state, err := readStateFile(); err == nil && w.Update(state.Services) {
+			return nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// waitWithChecks runs the pending services' checks every interval when no
+// daemon is running, saving results to state.json as 'check --update-state' does
+func waitWithChecks(ctx context.Context, config *Config, w *waitTracker, interval time.Duration) error {
+	checker := NewEnhancedChecker(CheckerOptions{
+		ExpiryWarning: time.Duration(config.ExpiryWarning) * time.Second,
+	})
+	for {
+		services := withDependencies(config.Services, filterServices(config.Services, w.Pending()))
+		results := checker.CheckBatch(ctx, services)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		updateState(func(state *State) {
+			state.Services = mergeStatuses(config.Services, results, state)
+		})
+		if w.Update(results) {
+			return nil
+		}
+
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
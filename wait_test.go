@@ -0,0 +1,56 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestWaitTracker(t *testing.T) {
+	services := []Service{{Name: "VPN"}, {Name: "Vault"}, {Name: "GitHub"}}
+	w := newWaitTracker(services, StateOK)
+	w.notBefore = time.Now()
+	before := w.notBefore.Add(-time.Minute)
+	after := w.notBefore.Add(time.Second)
+
+	// Results from before the wait don't count; disabled is final
+	done := w.Update([]ServiceStatus{
+		{Name: "VPN", State: StateDisabled},
+		{Name: "Vault", State: StateOK, LastChecked: before},
+		{Name: "GitHub", State: StateUnauthenticated, LastChecked: after},
+	})
+	if done {
+		t.Fatal("done before Vault and GitHub were ok")
+	}
+	if got := w.Pending(); !slices.Equal(got, []string{"Vault", "GitHub"}) {
+		t.Errorf("got pending %v", got)
+	}
+
+	// An expired session isn't ok yet
+	expired := time.Now().Add(-time.Minute)
+	w.Update([]ServiceStatus{{Name: "GitHub", State: StateOK, LastChecked: after, ExpiresAt: &expired}})
+	if got := w.Pending(); !slices.Equal(got, []string{"Vault", "GitHub"}) {
+		t.Errorf("got pending %v after an expired result", got)
+	}
+
+	done = w.Update([]ServiceStatus{
+		{Name: "Vault", State: StateOK, LastChecked: after},
+		{Name: "GitHub", State: StateOK, LastChecked: after},
+	})
+	if !done || len(w.Pending()) != 0 {
+		t.Errorf("not done, still pending %v", w.Pending())
+	}
+}
+
+func TestWaitTrackerOtherStates(t *testing.T) {
+	w := newWaitTracker([]Service{{Name: "VPN"}, {Name: "Okta"}}, StateUnauthenticated)
+	if !w.Update([]ServiceStatus{{Name: "VPN", State: StateUnauthenticated}, {Name: "Okta", State: StateDisabled}}) {
+		t.Errorf("still pending %v", w.Pending())
+	}
+
+	// Waiting for disabled itself works too
+	w = newWaitTracker([]Service{{Name: "VPN"}}, StateDisabled)
+	if !w.Update([]ServiceStatus{{Name: "VPN", State: StateDisabled}}) {
+		t.Error("disabled service didn't reach disabled")
+	}
+}